@@ -1,12 +1,54 @@
 package alert
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"sort"
 	"strings"
 	"time"
 )
 
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical"
+	SeverityWarning  Severity = "warning"
+	SeverityInfo     Severity = "info"
+)
+
+// Status of an alert, an alert is resolved once its entity is healthy or gone in a later run
+type Status string
+
+const (
+	StatusFiring   Status = "firing"
+	StatusResolved Status = "resolved"
+)
+
+var severityToOrder = map[Severity]int{
+	SeverityCritical: 1,
+	SeverityWarning:  2,
+	SeverityInfo:     3,
+}
+
+func ParseSeverity(value string) (Severity, error) {
+	severity := Severity(strings.ToLower(strings.TrimSpace(value)))
+	if _, found := severityToOrder[severity]; !found {
+		return "", fmt.Errorf("unknown severity '%v', expected one of critical/warning/info", value)
+	}
+	return severity, nil
+}
+
+// MoreSevere returns the more severe of the two, an unknown severity is considered the least severe
+func MoreSevere(a Severity, b Severity) Severity {
+	orderA, foundA := severityToOrder[a]
+	orderB, foundB := severityToOrder[b]
+	if !foundB || (foundA && orderA <= orderB) {
+		return a
+	}
+	return b
+}
+
 var kindToOrder = map[string]int{
 	"Node":       1,
 	"Namespace":  2,
@@ -14,24 +56,45 @@ var kindToOrder = map[string]int{
 	"Pod":        4,
 }
 
+// EntityAlert of an unhealthy entity, or of its recovery once resolved. The cluster alias and metadata are as configured
+// for the cluster, for sinks to render and route on. The timestamp of a resolved alert is when it started firing.
 type EntityAlert struct {
 	ClusterName         string            `json:"cluster_name"`
+	ClusterAlias        string            `json:"cluster_alias,omitempty"`
+	ClusterMetadata     map[string]string `json:"cluster_metadata,omitempty"`
+	Fingerprint         string            `json:"fingerprint"`
+	Status              Status            `json:"status"`
 	Namespace           string            `json:"namespace,omitempty"`
 	Name                string            `json:"name"`
 	Kind                string            `json:"kind"`
+	Severity            Severity          `json:"severity"`
 	Node                string            `json:"node,omitempty"`
 	Messages            []string          `json:"messages,omitempty"`
 	Events              []string          `json:"events,omitempty"`
 	LogsByContainerName map[string]string `json:"logs_by_container_name,omitempty"`
+	LikelyCause         string            `json:"likely_cause,omitempty"`
+	Redactions          int               `json:"redactions,omitempty"`
 	Timestamp           time.Time         `json:"timestamp"`
+	ResolvedAt          *time.Time        `json:"resolved_at,omitempty"`
 }
 
 type Alerts struct {
-	AlertsByClusterName map[string]EntityAlerts `json:"alerts_by_cluster_name"`
+	AlertsByClusterName       map[string]EntityAlerts         `json:"alerts_by_cluster_name"`
+	CapabilitiesByClusterName map[string]*ClusterCapabilities `json:"capabilities_by_cluster_name,omitempty"`
 }
 
 func NewAlerts() *Alerts {
-	return &Alerts{AlertsByClusterName: map[string]EntityAlerts{}}
+	return &Alerts{
+		AlertsByClusterName:       map[string]EntityAlerts{},
+		CapabilitiesByClusterName: map[string]*ClusterCapabilities{},
+	}
+}
+
+// AddCapabilities of a cluster, unknown capabilities are not added
+func (alerts *Alerts) AddCapabilities(clusterName string, capabilities *ClusterCapabilities) {
+	if capabilities != nil {
+		alerts.CapabilitiesByClusterName[clusterName] = capabilities
+	}
 }
 
 func (alerts *Alerts) AddEntityAlerts(entityAlerts EntityAlerts) {
@@ -53,6 +116,43 @@ func (alerts *Alerts) Empty() bool {
 	return true
 }
 
+// LimitLogs drops logs once their total size exceeds the byte budget, so a noisy cluster cannot produce a huge report.
+// Logs of more severe alerts are kept first. It returns the number of containers whose logs were dropped.
+func (alerts *Alerts) LimitLogs(byteBudget int) (dropped int) {
+	if byteBudget <= 0 {
+		return 0
+	}
+
+	var clusterNames []string
+	for clusterName := range alerts.AlertsByClusterName {
+		clusterNames = append(clusterNames, clusterName)
+	}
+	sort.Strings(clusterNames)
+	var all EntityAlerts
+	for _, clusterName := range clusterNames {
+		all = append(all, alerts.AlertsByClusterName[clusterName]...)
+	}
+	sort.Stable(all)
+
+	for _, entityAlert := range all {
+		var containerNames []string
+		for containerName := range entityAlert.LogsByContainerName {
+			containerNames = append(containerNames, containerName)
+		}
+		sort.Strings(containerNames)
+		for _, containerName := range containerNames {
+			size := len(entityAlert.LogsByContainerName[containerName])
+			if size > byteBudget {
+				delete(entityAlert.LogsByContainerName, containerName)
+				dropped++
+				continue
+			}
+			byteBudget -= size
+		}
+	}
+	return
+}
+
 type EntityAlerts []*EntityAlert
 
 var _ sort.Interface = &EntityAlerts{}
@@ -62,15 +162,30 @@ func (alerts EntityAlerts) Len() int {
 }
 
 func (alerts EntityAlerts) Less(i, j int) bool {
+	severity1, severityFound1 := severityToOrder[alerts[i].Severity]
+	severity2, severityFound2 := severityToOrder[alerts[j].Severity]
+	if severityFound1 != severityFound2 {
+		return severityFound1
+	}
+	if severity1 != severity2 {
+		return severity1 < severity2
+	}
 	kind1, found1 := kindToOrder[alerts[i].Kind]
 	kind2, found2 := kindToOrder[alerts[j].Kind]
-	if found1 == found2 {
-		if kind1 == kind2 {
-			return alerts[i].Name < alerts[j].Name
-		}
+	if found1 != found2 {
+		return found1
+	}
+	if kind1 != kind2 {
 		return kind1 < kind2
 	}
-	return found1
+	// kinds with no order, and objects of the same name in different namespaces, are ordered by name as well
+	if alerts[i].Kind != alerts[j].Kind {
+		return alerts[i].Kind < alerts[j].Kind
+	}
+	if alerts[i].Namespace != alerts[j].Namespace {
+		return alerts[i].Namespace < alerts[j].Namespace
+	}
+	return alerts[i].Name < alerts[j].Name
 }
 
 func (alerts EntityAlerts) Swap(i, j int) {
@@ -79,9 +194,33 @@ func (alerts EntityAlerts) Swap(i, j int) {
 	alerts[j] = tmp
 }
 
+// Fingerprint identifies an ongoing issue of an entity across reports, regardless of the order of its messages.
+// The messages are expected to be normalized, without the temporal parts that change between reports.
+func Fingerprint(clusterName string, kind string, namespace string, name string, normalizedMessages []string) string {
+	messages := append([]string{}, normalizedMessages...)
+	sort.Strings(messages)
+	hash := sha256.New()
+	for _, part := range append([]string{clusterName, kind, namespace, name}, messages...) {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// ClusterDisplayName is the cluster alias if configured, or its name otherwise
+func (entityAlert *EntityAlert) ClusterDisplayName() string {
+	if entityAlert.ClusterAlias != "" {
+		return entityAlert.ClusterAlias
+	}
+	return entityAlert.ClusterName
+}
+
 func (alerts *Alerts) String() string {
 	builder := strings.Builder{}
 	for clusterName, clusterAlerts := range alerts.AlertsByClusterName {
+		if len(clusterAlerts) > 0 {
+			clusterName = clusterAlerts[0].ClusterDisplayName()
+		}
 		builder.WriteString(fmt.Sprintf("Found %v alerts for cluster %v:\n", len(clusterAlerts), clusterName))
 		for _, entityAlert := range clusterAlerts {
 			builder.WriteString(entityAlert.String())
@@ -93,13 +232,22 @@ func (alerts *Alerts) String() string {
 
 func (entityAlert *EntityAlert) String() string {
 	builder := strings.Builder{}
+	if entityAlert.Status == StatusResolved {
+		builder.WriteString(fmt.Sprintf("[%v] ", entityAlert.Status))
+	} else if entityAlert.Severity != "" {
+		builder.WriteString(fmt.Sprintf("[%v] ", entityAlert.Severity))
+	}
 	builder.WriteString(fmt.Sprintf("%v ", entityAlert.Kind))
 	if entityAlert.Namespace != "" {
 		builder.WriteString(fmt.Sprintf("%v/%v", entityAlert.Namespace, entityAlert.Name))
 	} else {
 		builder.WriteString(entityAlert.Name)
 	}
-	builder.WriteString(" is un-healthy:")
+	if entityAlert.Status == StatusResolved {
+		builder.WriteString(" is resolved:")
+	} else {
+		builder.WriteString(" is un-healthy:")
+	}
 	for _, message := range entityAlert.Messages {
 		builder.WriteString("\n")
 		builder.WriteString(message)
@@ -110,6 +258,10 @@ func (entityAlert *EntityAlert) String() string {
 			builder.WriteString(event)
 		}
 	}
+	if entityAlert.LikelyCause != "" {
+		builder.WriteString("\n")
+		builder.WriteString(fmt.Sprintf("Likely cause: %v", entityAlert.LikelyCause))
+	}
 	if len(entityAlert.LogsByContainerName) > 0 {
 		for containerName, logs := range entityAlert.LogsByContainerName {
 			builder.WriteString("\n")
@@ -119,5 +271,9 @@ func (entityAlert *EntityAlert) String() string {
 			builder.WriteString("\n--------")
 		}
 	}
+	if entityAlert.Fingerprint != "" {
+		builder.WriteString("\n")
+		builder.WriteString(fmt.Sprintf("Fingerprint: %v", entityAlert.Fingerprint))
+	}
 	return builder.String()
 }
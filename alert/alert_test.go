@@ -0,0 +1,68 @@
+package alert
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAlerts_LimitLogs(t *testing.T) {
+	warning := &EntityAlert{
+		ClusterName:         "a",
+		Name:                "warning",
+		Kind:                "Pod",
+		Severity:            SeverityWarning,
+		LogsByContainerName: map[string]string{"app": strings.Repeat("w", 60)},
+	}
+	critical := &EntityAlert{
+		ClusterName:         "b",
+		Name:                "critical",
+		Kind:                "Pod",
+		Severity:            SeverityCritical,
+		LogsByContainerName: map[string]string{"app": strings.Repeat("c", 60), "sidecar": strings.Repeat("s", 30)},
+	}
+	alerts := NewAlerts()
+	alerts.AddEntityAlerts(EntityAlerts{warning, critical})
+
+	dropped := alerts.LimitLogs(100)
+	assert.Equal(t, 1, dropped)
+	assert.Equal(t, 2, len(critical.LogsByContainerName), "logs of the more severe alert are kept")
+	assert.Empty(t, warning.LogsByContainerName)
+
+	assert.Equal(t, 0, alerts.LimitLogs(0), "no budget")
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := Fingerprint("prod", "Pod", "default", "api-1", []string{"a", "b"})
+	assert.Len(t, fingerprint, 16)
+	assert.Equal(t, fingerprint, Fingerprint("prod", "Pod", "default", "api-1", []string{"b", "a"}), "messages order does not matter")
+	assert.NotEqual(t, fingerprint, Fingerprint("staging", "Pod", "default", "api-1", []string{"a", "b"}))
+	assert.NotEqual(t, fingerprint, Fingerprint("prod", "Pod", "default", "api-1", []string{"a"}))
+	assert.NotEqual(t, Fingerprint("prod", "Pod", "a", "b", nil), Fingerprint("prod", "Pod", "ab", "", nil), "fields are separated")
+}
+
+func TestEntityAlerts_Sort(t *testing.T) {
+	alerts := EntityAlerts{
+		{Kind: "Pod", Namespace: "team-b", Name: "api", Severity: SeverityWarning},
+		{Kind: "Deployment", Namespace: "team-a", Name: "api", Severity: SeverityWarning},
+		{Kind: "Pod", Namespace: "team-a", Name: "api", Severity: SeverityWarning},
+		{Kind: "Job", Namespace: "team-a", Name: "api", Severity: SeverityWarning},
+		{Kind: "Pod", Namespace: "team-a", Name: "worker", Severity: SeverityCritical},
+		{Kind: "Node", Name: "node-1", Severity: SeverityWarning},
+	}
+	sort.Sort(alerts)
+
+	var keys []string
+	for _, entityAlert := range alerts {
+		keys = append(keys, entityAlert.Kind+" "+entityAlert.Namespace+"/"+entityAlert.Name)
+	}
+	assert.Equal(t, []string{
+		"Pod team-a/worker",
+		"Node /node-1",
+		"Pod team-a/api",
+		"Pod team-b/api",
+		"Deployment team-a/api",
+		"Job team-a/api",
+	}, keys)
+}
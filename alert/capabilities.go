@@ -0,0 +1,21 @@
+package alert
+
+// ClusterCapabilities are the apis served by a cluster, as detected with the discovery api
+type ClusterCapabilities struct {
+	GroupVersions []string `json:"group_versions"`
+	CRDs          []string `json:"crds,omitempty"`
+}
+
+// Serves tells whether the group version, e.g. 'events.k8s.io/v1', is served.
+// Unknown capabilities are assumed to serve everything.
+func (capabilities *ClusterCapabilities) Serves(groupVersion string) bool {
+	if capabilities == nil {
+		return true
+	}
+	for _, served := range capabilities.GroupVersions {
+		if served == groupVersion {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+)
+
+// InClusterName is the cluster name when running inside the cluster, rather than a kubeconfig context name
+const InClusterName = "in-cluster"
+
+// ClusterInfo describes a cluster for sinks to render and route alerts on, matched by its kubeconfig context name
+type ClusterInfo struct {
+	Context  string            `yaml:"context" json:"context"`
+	Alias    string            `yaml:"alias" json:"alias,omitempty"`
+	Metadata map[string]string `yaml:"metadata" json:"metadata,omitempty"`
+}
+
+type ClustersInfo struct {
+	Clusters []*ClusterInfo `yaml:"clusters" json:"clusters"`
+}
+
+// LoadClustersInfo reads the aliases and metadata of clusters from a yaml file, an empty path results in no clusters info
+func LoadClustersInfo(filePath string) (*ClustersInfo, error) {
+	infos := &ClustersInfo{}
+	if filePath == "" {
+		return infos, nil
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clusters file from '%v': %v", filePath, err)
+	}
+	err = yaml.Unmarshal(content, infos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize yaml from '%v': %v", filePath, err)
+	}
+	contexts := map[string]bool{}
+	for i, info := range infos.Clusters {
+		if info.Context == "" {
+			return nil, fmt.Errorf("invalid clusters file '%v': cluster #%v has no context", filePath, i+1)
+		}
+		if contexts[info.Context] {
+			return nil, fmt.Errorf("invalid clusters file '%v': context '%v' is not unique", filePath, info.Context)
+		}
+		contexts[info.Context] = true
+	}
+	return infos, nil
+}
+
+// Get the info of a cluster by its context name, a cluster with no info results in an empty info
+func (infos *ClustersInfo) Get(contextName string) *ClusterInfo {
+	if infos != nil {
+		for _, info := range infos.Clusters {
+			if info.Context == contextName {
+				return info
+			}
+		}
+	}
+	return &ClusterInfo{Context: contextName}
+}
+
+// set the alias and metadata of a cluster, overriding those of the file
+func (infos *ClustersInfo) set(contextName string, alias string, metadata map[string]string) {
+	if alias == "" && len(metadata) == 0 {
+		return
+	}
+	var info *ClusterInfo
+	for _, existing := range infos.Clusters {
+		if existing.Context == contextName {
+			info = existing
+		}
+	}
+	if info == nil {
+		info = &ClusterInfo{Context: contextName}
+		infos.Clusters = append(infos.Clusters, info)
+	}
+	if alias != "" {
+		info.Alias = alias
+	}
+	if len(metadata) > 0 && info.Metadata == nil {
+		info.Metadata = map[string]string{}
+	}
+	for key, value := range metadata {
+		info.Metadata[key] = value
+	}
+}
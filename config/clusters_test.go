@@ -0,0 +1,78 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func writeClustersFile(t *testing.T, content string) string {
+	filePath := path.Join(t.TempDir(), "clusters.yaml")
+	err := ioutil.WriteFile(filePath, []byte(content), 0644)
+	require.Nil(t, err)
+	return filePath
+}
+
+func TestLoadClustersInfo(t *testing.T) {
+	// language=yaml
+	infos, err := LoadClustersInfo(writeClustersFile(t, `
+clusters:
+  - context: arn:aws:eks:us-east-1:123:cluster/prod-a
+    alias: prod-a
+    metadata:
+      environment: prod
+      region: us-east-1
+  - context: in-cluster
+    alias: local
+`))
+	require.Nil(t, err)
+
+	info := infos.Get("arn:aws:eks:us-east-1:123:cluster/prod-a")
+	assert.Equal(t, "prod-a", info.Alias)
+	assert.Equal(t, map[string]string{"environment": "prod", "region": "us-east-1"}, info.Metadata)
+
+	info = infos.Get("unknown")
+	assert.Equal(t, "unknown", info.Context)
+	assert.Equal(t, "", info.Alias)
+
+	infos.set(InClusterName, "", map[string]string{"owner": "platform"})
+	info = infos.Get(InClusterName)
+	assert.Equal(t, "local", info.Alias)
+	assert.Equal(t, map[string]string{"owner": "platform"}, info.Metadata)
+
+	var nilInfos *ClustersInfo
+	assert.Equal(t, "prod", nilInfos.Get("prod").Context)
+}
+
+func TestLoadClustersInfo_Invalid(t *testing.T) {
+	// language=yaml
+	_, err := LoadClustersInfo(writeClustersFile(t, `
+clusters:
+  - alias: no-context
+`))
+	require.NotNil(t, err)
+
+	// language=yaml
+	_, err = LoadClustersInfo(writeClustersFile(t, `
+clusters:
+  - context: prod
+  - context: prod
+`))
+	require.NotNil(t, err)
+}
+
+func TestFromArgs_InClusterAliasAndMetadata(t *testing.T) {
+	config, err := FromArgs([]string{
+		"executable",
+		"--cluster-alias",
+		"prod-a",
+		"--cluster-metadata",
+		"environment=prod,region=us-east-1",
+	})
+	require.Nil(t, err)
+	info := config.ClustersInfo.Get(InClusterName)
+	assert.Equal(t, "prod-a", info.Alias)
+	assert.Equal(t, map[string]string{"environment": "prod", "region": "us-east-1"}, info.Metadata)
+}
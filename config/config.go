@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/internal"
 	"github.com/reallyliri/kubescout/internal/kubeconfig"
+	"github.com/reallyliri/kubescout/internal/rules"
 	"github.com/reallyliri/kubescout/sink"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
+	"k8s.io/apimachinery/pkg/labels"
 	"os"
 	"path/filepath"
 	"time"
@@ -15,6 +19,9 @@ import (
 
 type Config struct {
 	PodLogsTail                      int64
+	LogsExcerptBytes                 int
+	LogsLimitBytes                   int64
+	LogsTotalBytes                   int
 	EventsLimit                      int64
 	KubeconfigFilePath               string
 	RunningInCluster                 bool
@@ -25,15 +32,39 @@ type Config struct {
 	PodTerminationGracePeriodSeconds int64
 	PodRestartGraceCount             int32
 	NodeResourceUsageThreshold       float64
-	ExcludeNamespaces                []string
-	IncludeNamespaces                []string
+	ExcludeNamespaces                *internal.NamePatterns
+	IncludeNamespaces                *internal.NamePatterns
+	NamespaceSelector                labels.Selector
+	WorkloadSelector                 string
 	MessagesDeduplicationDuration    time.Duration
 	StoreFilePath                    string
 	OutputMode                       string
-	ContextName                      string
+	ContextNames                     []string
 	AllContexts                      bool
 	ExcludeContexts                  []string
 	NotInCluster                     bool
+	RedactPatterns                   []string
+	SeverityByKind                   map[string]alert.Severity
+	SeverityByReason                 map[string]alert.Severity
+	SeverityByNamespace              map[string]alert.Severity
+	Rules                            *rules.RuleSet
+	IgnoreRules                      *IgnoreRules
+	ClustersInfo                     *ClustersInfo
+	NamespaceConcurrency             int
+	ContextConcurrency               int
+	KubeAPIQPS                       float32
+	KubeAPIBurst                     int
+	RequestTimeout                   time.Duration
+	RunTimeout                       time.Duration
+	Watch                            bool
+	WatchDebounceDuration            time.Duration
+	WatchSyncTimeout                 time.Duration
+	DumpDirPath                      string
+	RecordDirPath                    string
+	ReplayDirPath                    string
+	HubSecretsSelector               string
+	HubSecretsNamespace              string
+	HubExecCommands                  []string
 }
 
 var Flags = []cli.Flag{
@@ -52,9 +83,30 @@ var Flags = []cli.Flag{
 		Required: false,
 		EnvVars:  []string{"LOGS_TAIL"},
 	},
+	&cli.IntFlag{
+		Name:     "logs-excerpt-bytes",
+		Usage:    "byte budget per alert for logs excerpts around detected errors (panics, stack traces, fatal/error lines, OOM), use 0 to report the plain logs tail",
+		Value:    4096,
+		Required: false,
+		EnvVars:  []string{"LOGS_EXCERPT_BYTES"},
+	},
+	&cli.Int64Flag{
+		Name:     "logs-limit-bytes",
+		Usage:    "maximum bytes of logs to keep per container, the most recent are kept, logs are read since shortly before the problem started, use 0 for no limit",
+		Value:    256 * 1024,
+		Required: false,
+		EnvVars:  []string{"LOGS_LIMIT_BYTES"},
+	},
+	&cli.IntFlag{
+		Name:     "logs-total-bytes",
+		Usage:    "byte budget of logs across all alerts of a run, logs of the least severe alerts are dropped first, use 0 for no budget",
+		Value:    1024 * 1024,
+		Required: false,
+		EnvVars:  []string{"LOGS_TOTAL_BYTES"},
+	},
 	&cli.Int64Flag{
 		Name:     "events-limit",
-		Usage:    "Maximum number of namespace events to fetch",
+		Usage:    "Maximum number of non normal events to fetch per namespace, only events seen since the previous run are considered",
 		Value:    150,
 		Required: false,
 		EnvVars:  []string{"EVENTS_LIMIT"},
@@ -62,7 +114,7 @@ var Flags = []cli.Flag{
 	&cli.StringFlag{
 		Name:     "kubeconfig",
 		Aliases:  []string{"k"},
-		Usage:    "kubeconfig file path, defaults to env var KUBECONFIG or ~/.kube/config, can be omitted when running in cluster",
+		Usage:    "kubeconfig file path, or a list of files and directories to merge like kubectl separated by ':', defaults to env var KUBECONFIG or ~/.kube/config, can be omitted when running in cluster",
 		Required: false,
 		EnvVars:  []string{"KUBECONFIG"},
 	},
@@ -120,7 +172,7 @@ var Flags = []cli.Flag{
 		Name:     "exclude-ns",
 		Aliases:  []string{"e"},
 		Value:    "",
-		Usage:    "namespaces to skip",
+		Usage:    "namespaces to skip, as names, globs (e.g. 'pr-*') or regexes wrapped in slashes (e.g. '/^pr-\\d+$/')",
 		Required: false,
 		EnvVars:  []string{"EXCLUDE_NS"},
 	},
@@ -128,10 +180,24 @@ var Flags = []cli.Flag{
 		Name:     "include-ns",
 		Aliases:  []string{"n"},
 		Value:    "",
-		Usage:    "namespaces to include (will skip any not listed if this option is used)",
+		Usage:    "namespaces to include (will skip any not listed if this option is used), as names, globs or regexes wrapped in slashes",
 		Required: false,
 		EnvVars:  []string{"INCLUDE_NS"},
 	},
+	&cli.StringFlag{
+		Name:     "ns-selector",
+		Value:    "",
+		Usage:    "label selector of namespaces to include, e.g. 'team=payments,env!=dev'",
+		Required: false,
+		EnvVars:  []string{"NS_SELECTOR"},
+	},
+	&cli.StringFlag{
+		Name:     "workload-selector",
+		Value:    "",
+		Usage:    "label selector of pods and replica sets to scan, e.g. 'app.kubernetes.io/part-of=checkout'",
+		Required: false,
+		EnvVars:  []string{"WORKLOAD_SELECTOR"},
+	},
 	&cli.IntFlag{
 		Name:     "dedup-minutes",
 		Aliases:  []string{"d"},
@@ -160,7 +226,7 @@ var Flags = []cli.Flag{
 		Name:     "context",
 		Aliases:  []string{"c"},
 		Value:    "",
-		Usage:    "context name to use from kubeconfig, defaults to current context",
+		Usage:    "a comma separated list of kubeconfig contexts to use, as names, globs (e.g. 'prod-*') or regexes wrapped in slashes, defaults to current context",
 		Required: false,
 	},
 	&cli.BoolFlag{
@@ -180,8 +246,175 @@ var Flags = []cli.Flag{
 	&cli.StringFlag{
 		Name:     "exclude-contexts",
 		Value:    "",
-		Usage:    "a comma separated list of kubeconfig context names to skip, only relevant if 'all-contexts' flag is set",
+		Usage:    "a comma separated list of kubeconfig contexts to skip, as names, globs (e.g. '*-sandbox') or regexes wrapped in slashes",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "concurrency",
+		Value:    4,
+		Usage:    "number of namespaces to scan concurrently",
+		Required: false,
+		EnvVars:  []string{"CONCURRENCY"},
+	},
+	&cli.IntFlag{
+		Name:     "contexts-concurrency",
+		Value:    4,
+		Usage:    "number of contexts to scan concurrently, only relevant when scanning multiple contexts",
+		Required: false,
+		EnvVars:  []string{"CONTEXTS_CONCURRENCY"},
+	},
+	&cli.Float64Flag{
+		Name:     "kube-api-qps",
+		Value:    20,
+		Usage:    "maximal queries per second to the kubernetes api server",
+		Required: false,
+		EnvVars:  []string{"KUBE_API_QPS"},
+	},
+	&cli.IntFlag{
+		Name:     "kube-api-burst",
+		Value:    40,
+		Usage:    "maximal burst of queries to the kubernetes api server, over the qps limit",
+		Required: false,
+		EnvVars:  []string{"KUBE_API_BURST"},
+	},
+	&cli.IntFlag{
+		Name:     "request-timeout-sec",
+		Value:    30,
+		Usage:    "timeout in seconds of a single request to the kubernetes api server, or 0 for no timeout",
+		Required: false,
+		EnvVars:  []string{"REQUEST_TIMEOUT_SEC"},
+	},
+	&cli.IntFlag{
+		Name:     "timeout-sec",
+		Value:    0,
+		Usage:    "overall deadline in seconds of a scan, after which partial results are reported along with a 'scan incomplete' alert, or 0 for no deadline",
+		Required: false,
+		EnvVars:  []string{"TIMEOUT_SEC"},
+	},
+	&cli.BoolFlag{
+		Name:     "watch",
+		Aliases:  []string{"w"},
+		Value:    false,
+		Usage:    "keep running and diagnose on every change in the cluster, instead of a single scan",
+		Required: false,
+		EnvVars:  []string{"WATCH"},
+	},
+	&cli.IntFlag{
+		Name:     "watch-debounce-sec",
+		Value:    10,
+		Usage:    "time in seconds to wait for further changes before diagnosing, only relevant if 'watch' flag is set",
+		Required: false,
+		EnvVars:  []string{"WATCH_DEBOUNCE_SEC"},
+	},
+	&cli.IntFlag{
+		Name:     "watch-sync-timeout-sec",
+		Value:    60,
+		Usage:    "time in seconds to wait for the initial listing of a watched cluster, after which it is reported as a 'scan incomplete' alert while the watch keeps trying, only relevant if 'watch' flag is set",
+		Required: false,
+		EnvVars:  []string{"WATCH_SYNC_TIMEOUT_SEC"},
+	},
+	&cli.StringFlag{
+		Name:     "from-dump",
+		Value:    "",
+		Usage:    "diagnose offline from a directory of 'kubectl cluster-info dump --output-directory' or 'kubectl get -o json' outputs, instead of a live cluster",
+		Required: false,
+		EnvVars:  []string{"FROM_DUMP"},
+	},
+	&cli.StringFlag{
+		Name:     "record",
+		Value:    "",
+		Usage:    "directory to record all kubernetes api responses of the scan to, for replaying the exact same diagnosis later with 'replay'",
+		Required: false,
+		EnvVars:  []string{"RECORD"},
+	},
+	&cli.StringFlag{
+		Name:     "replay",
+		Value:    "",
+		Usage:    "diagnose the responses recorded by 'record' to the given directory, as of the time they were recorded, instead of a live cluster",
+		Required: false,
+		EnvVars:  []string{"REPLAY"},
+	},
+	&cli.StringSliceFlag{
+		Name:     "redact-pattern",
+		Usage:    "regex of secrets to redact from alerts before reporting and from the messages saved to the store, in addition to the builtin detectors (jwt, aws keys, bearer tokens, private keys, connection strings passwords), can be repeated. A group named 'secret' limits the redaction to that group",
+		Required: false,
+		EnvVars:  []string{"REDACT_PATTERNS"},
+	},
+	&cli.StringFlag{
+		Name:     "severity-by-kind",
+		Value:    "",
+		Usage:    "a comma separated list of kind=severity pairs overriding alerts severity (critical/warning/info), e.g. 'ReplicaSet=info'",
+		Required: false,
+		EnvVars:  []string{"SEVERITY_BY_KIND"},
+	},
+	&cli.StringFlag{
+		Name:     "severity-by-reason",
+		Value:    "",
+		Usage:    "a comma separated list of reason=severity pairs overriding alerts severity, e.g. 'CrashLoopBackOff=warning,Unhealthy=warning', takes precedence over namespace and kind overrides, OOMKilled and CrashLoopBackOff are critical by default",
+		Required: false,
+		EnvVars:  []string{"SEVERITY_BY_REASON"},
+	},
+	&cli.StringFlag{
+		Name:     "severity-by-ns",
+		Value:    "",
+		Usage:    "a comma separated list of namespace=severity pairs overriding alerts severity, e.g. 'dev=info', takes precedence over kind overrides",
+		Required: false,
+		EnvVars:  []string{"SEVERITY_BY_NS"},
+	},
+	&cli.StringFlag{
+		Name:     "rules-file",
+		Value:    "",
+		Usage:    "path to a yaml file of custom alert rules, each matching on kind/namespaces/labels with an expression evaluated against the raw object",
+		Required: false,
+		EnvVars:  []string{"RULES_FILE"},
+	},
+	&cli.StringFlag{
+		Name:     "ignore-file",
+		Value:    "",
+		Usage:    "path to a yaml file of ignore rules for events, container waiting reasons and standalone events kinds, scoped by cluster and namespace regexes",
+		Required: false,
+		EnvVars:  []string{"IGNORE_FILE"},
+	},
+	&cli.StringFlag{
+		Name:     "clusters-file",
+		Value:    "",
+		Usage:    "path to a yaml file of aliases and metadata (e.g. environment, region, owner team) per kubeconfig context, attached to the alerts of the cluster",
+		Required: false,
+		EnvVars:  []string{"CLUSTERS_FILE"},
+	},
+	&cli.StringFlag{
+		Name:     "cluster-alias",
+		Value:    "",
+		Usage:    "display name of the cluster when running in cluster",
+		Required: false,
+		EnvVars:  []string{"CLUSTER_ALIAS"},
+	},
+	&cli.StringFlag{
+		Name:     "cluster-metadata",
+		Value:    "",
+		Usage:    "a comma separated list of key=value pairs of metadata attached to the alerts when running in cluster, e.g. 'environment=prod,region=us-east-1'",
+		Required: false,
+		EnvVars:  []string{"CLUSTER_METADATA"},
+	},
+	&cli.StringFlag{
+		Name:     "hub-secrets-selector",
+		Value:    "",
+		Usage:    "label selector of secrets in the current cluster holding credentials of more clusters to scan, as a kubeconfig, Cluster API or Argo CD cluster secret, e.g. 'argocd.argoproj.io/secret-type=cluster'",
+		Required: false,
+		EnvVars:  []string{"HUB_SECRETS_SELECTOR"},
+	},
+	&cli.StringFlag{
+		Name:     "hub-secrets-namespace",
+		Value:    "argocd",
+		Usage:    "namespace of the cluster secrets, only relevant if 'hub-secrets-selector' flag is set",
 		Required: false,
+		EnvVars:  []string{"HUB_SECRETS_NAMESPACE"},
+	},
+	&cli.StringSliceFlag{
+		Name:     "hub-exec-commands",
+		Usage:    "commands which credentials of the cluster secrets are allowed to run, e.g. 'aws' for Argo CD 'awsAuthConfig', can be repeated. Credentials running any other command, such as exec providers, are rejected",
+		Required: false,
+		EnvVars:  []string{"HUB_EXEC_COMMANDS"},
 	},
 }
 
@@ -225,6 +458,9 @@ func flagSet(name string) (*flag.FlagSet, error) {
 func ParseConfig(c *cli.Context) (*Config, error) {
 	config := &Config{
 		PodLogsTail:                      c.Int64("logs-tail"),
+		LogsExcerptBytes:                 c.Int("logs-excerpt-bytes"),
+		LogsLimitBytes:                   c.Int64("logs-limit-bytes"),
+		LogsTotalBytes:                   c.Int("logs-total-bytes"),
 		EventsLimit:                      c.Int64("events-limit"),
 		KubeconfigFilePath:               c.String("kubeconfig"),
 		TimeFormat:                       c.String("time-format"),
@@ -233,17 +469,90 @@ func ParseConfig(c *cli.Context) (*Config, error) {
 		PodTerminationGracePeriodSeconds: c.Int64("pod-termination-grace-sec"),
 		PodRestartGraceCount:             int32(c.Int("pod-restart-grace-count")),
 		NodeResourceUsageThreshold:       c.Float64("node-resource-usage-threshold"),
-		ExcludeNamespaces:                splitListFlag(c.String("exclude-ns")),
-		IncludeNamespaces:                splitListFlag(c.String("include-ns")),
+		WorkloadSelector:                 c.String("workload-selector"),
+		NamespaceConcurrency:             c.Int("concurrency"),
+		ContextConcurrency:               c.Int("contexts-concurrency"),
+		KubeAPIQPS:                       float32(c.Float64("kube-api-qps")),
+		KubeAPIBurst:                     c.Int("kube-api-burst"),
+		RequestTimeout:                   time.Second * time.Duration(c.Int("request-timeout-sec")),
+		RunTimeout:                       time.Second * time.Duration(c.Int("timeout-sec")),
+		Watch:                            c.Bool("watch"),
+		WatchDebounceDuration:            time.Second * time.Duration(c.Int("watch-debounce-sec")),
+		WatchSyncTimeout:                 time.Second * time.Duration(c.Int("watch-sync-timeout-sec")),
+		DumpDirPath:                      c.String("from-dump"),
+		RecordDirPath:                    c.String("record"),
+		ReplayDirPath:                    c.String("replay"),
 		MessagesDeduplicationDuration:    time.Minute * time.Duration(c.Int("dedup-minutes")),
 		StoreFilePath:                    c.String("store-filepath"),
 		OutputMode:                       c.String("output"),
-		ContextName:                      c.String("context"),
+		ContextNames:                     splitPatternsFlag(c.String("context")),
 		AllContexts:                      c.Bool("all-contexts"),
-		ExcludeContexts:                  splitListFlag(c.String("exclude-contexts")),
+		ExcludeContexts:                  splitPatternsFlag(c.String("exclude-contexts")),
 		NotInCluster:                     c.Bool("not-in-cluster"),
+		RedactPatterns:                   c.StringSlice("redact-pattern"),
+		HubSecretsSelector:               c.String("hub-secrets-selector"),
+		HubSecretsNamespace:              c.String("hub-secrets-namespace"),
+		HubExecCommands:                  c.StringSlice("hub-exec-commands"),
 	}
 
+	var err error
+	config.SeverityByKind, err = parseSeverityMapFlag(c.String("severity-by-kind"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse severity-by-kind: %v", err)
+	}
+	config.SeverityByReason, err = parseSeverityMapFlag(c.String("severity-by-reason"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse severity-by-reason: %v", err)
+	}
+	config.SeverityByNamespace, err = parseSeverityMapFlag(c.String("severity-by-ns"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse severity-by-ns: %v", err)
+	}
+
+	config.ExcludeNamespaces, err = internal.CompileNamePatterns(splitPatternsFlag(c.String("exclude-ns")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse exclude-ns: %v", err)
+	}
+	config.IncludeNamespaces, err = internal.CompileNamePatterns(splitPatternsFlag(c.String("include-ns")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse include-ns: %v", err)
+	}
+	config.NamespaceSelector, err = labels.Parse(c.String("ns-selector"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ns-selector '%v': %v", c.String("ns-selector"), err)
+	}
+	_, err = labels.Parse(config.WorkloadSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workload-selector '%v': %v", config.WorkloadSelector, err)
+	}
+
+	if config.HubSecretsSelector != "" {
+		_, err = labels.Parse(config.HubSecretsSelector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse hub-secrets-selector '%v': %v", config.HubSecretsSelector, err)
+		}
+	}
+
+	config.Rules, err = rules.Load(c.String("rules-file"))
+	if err != nil {
+		return nil, err
+	}
+
+	config.IgnoreRules, err = LoadIgnoreRules(c.String("ignore-file"))
+	if err != nil {
+		return nil, err
+	}
+
+	config.ClustersInfo, err = LoadClustersInfo(c.String("clusters-file"))
+	if err != nil {
+		return nil, err
+	}
+	clusterMetadata, err := splitMapFlag(c.String("cluster-metadata"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cluster-metadata: %v", err)
+	}
+	config.ClustersInfo.set(InClusterName, c.String("cluster-alias"), clusterMetadata)
+
 	if config.StoreFilePath != "" {
 		dirPath := filepath.Dir(config.StoreFilePath)
 		err := validateDirectory(dirPath, true)
@@ -275,7 +584,43 @@ func ParseConfig(c *cli.Context) (*Config, error) {
 		log.SetLevel(log.InfoLevel)
 	}
 
-	if config.KubeconfigFilePath == "" {
+	if config.DumpDirPath != "" {
+		err = validateDirectory(config.DumpDirPath, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from-dump directory: %v", err)
+		}
+	}
+	if config.ReplayDirPath != "" {
+		err = validateDirectory(config.ReplayDirPath, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replay directory: %v", err)
+		}
+	}
+	if config.RecordDirPath != "" {
+		err = validateDirectory(config.RecordDirPath, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record directory: %v", err)
+		}
+	}
+	exclusiveModes := 0
+	for _, enabled := range []bool{config.Watch, config.DumpDirPath != "", config.RecordDirPath != "", config.ReplayDirPath != ""} {
+		if enabled {
+			exclusiveModes++
+		}
+	}
+	if exclusiveModes > 1 {
+		return nil, fmt.Errorf("only one of watch, from-dump, record and replay can be used")
+	}
+
+	offline := config.DumpDirPath != "" || config.ReplayDirPath != ""
+	if config.HubSecretsSelector != "" && offline {
+		return nil, fmt.Errorf("hub-secrets-selector can not be used along with from-dump or replay")
+	}
+	if config.HubSecretsSelector != "" && config.HubSecretsNamespace == "" {
+		// secrets of all namespaces would let anyone who can create a secret in any namespace add clusters
+		return nil, fmt.Errorf("hub-secrets-namespace is required along with hub-secrets-selector")
+	}
+	if config.KubeconfigFilePath == "" && !offline {
 		config.KubeconfigFilePath, config.RunningInCluster, err = kubeconfig.DefaultKubeconfigPath(config.NotInCluster)
 		if err != nil || (config.KubeconfigFilePath == "" && !config.RunningInCluster) {
 			return nil, fmt.Errorf("failed to determine default kubeconfig file path: %v", err)
@@ -3,6 +3,8 @@ package config
 import (
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -51,6 +53,66 @@ func TestFromArgs(t *testing.T) {
 	require.NotNil(t, config.Locale)
 	require.Equal(t, time.UTC, config.Locale)
 	require.Equal(t, "path/kubeconfig", config.KubeconfigFilePath)
-	require.Equal(t, []string{"ns1", "ns2"}, config.ExcludeNamespaces)
-	require.Equal(t, []string{"ns3"}, config.IncludeNamespaces)
+	require.Equal(t, []string{"ns1", "ns2"}, config.ExcludeNamespaces.ExactNames())
+	require.Equal(t, []string{"ns3"}, config.IncludeNamespaces.ExactNames())
+}
+
+func TestFromArgs_NamespacePatterns(t *testing.T) {
+	config, err := FromArgs([]string{"executable", "--include-ns", `/^x\d{2,4}$/,kube-*`, "--ns-selector", "team=payments"})
+	require.Nil(t, err)
+	require.True(t, config.IncludeNamespaces.Matches("x123"))
+	require.True(t, config.IncludeNamespaces.Matches("kube-system"))
+	require.False(t, config.IncludeNamespaces.Matches("x1"))
+	require.Equal(t, "team=payments", config.NamespaceSelector.String())
+
+	_, err = FromArgs([]string{"executable", "--include-ns", "/(/"})
+	require.NotNil(t, err, "invalid patterns fail at startup")
+	_, err = FromArgs([]string{"executable", "--ns-selector", "team in"})
+	require.NotNil(t, err, "invalid selectors fail at startup")
+}
+
+func TestSplitPatternsFlag(t *testing.T) {
+	require.Equal(t, []string{}, splitPatternsFlag(""))
+	require.Equal(t, []string{"a", "b*"}, splitPatternsFlag("a,b*"))
+	require.Equal(t, []string{`/^x\d{2,4}$/`, " ns"}, splitPatternsFlag(`/^x\d{2,4}$/, ns`))
+	require.Equal(t, []string{"a", `/b/c,d/`}, splitPatternsFlag(`a,/b/c,d/`))
+	require.Equal(t, []string{"a/b", "c"}, splitPatternsFlag("a/b,c"))
+}
+
+func TestFromArgs_RulesFile(t *testing.T) {
+	rulesFilePath := path.Join(t.TempDir(), "rules.yaml")
+	// language=yaml
+	err := ioutil.WriteFile(rulesFilePath, []byte(`
+rules:
+  - name: no-owner
+    match:
+      kind: Pod
+    expr: object.metadata.ownerReferences == nil
+`), 0644)
+	require.Nil(t, err)
+	config, err := FromArgs([]string{"executable", "--rules-file", rulesFilePath})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(config.Rules.Rules))
+
+	// language=yaml
+	err = ioutil.WriteFile(rulesFilePath, []byte(`
+rules:
+  - name: broken
+    match:
+      kind: Pod
+    expr: object.metadata.name ==
+`), 0644)
+	require.Nil(t, err)
+	_, err = FromArgs([]string{"executable", "--rules-file", rulesFilePath})
+	require.NotNil(t, err, "invalid rules fail at startup")
+}
+
+func TestFromArgs_HubSecrets(t *testing.T) {
+	config, err := FromArgs([]string{"executable", "-k", "path/kubeconfig", "--hub-secrets-selector", "argocd.argoproj.io/secret-type=cluster"})
+	require.Nil(t, err)
+	require.Equal(t, "argocd", config.HubSecretsNamespace)
+	require.Empty(t, config.HubExecCommands, "commands of cluster secrets are not run by default")
+
+	_, err = FromArgs([]string{"executable", "-k", "path/kubeconfig", "--hub-secrets-selector", "argocd.argoproj.io/secret-type=cluster", "--hub-secrets-namespace", ""})
+	require.NotNil(t, err, "secrets of all namespaces are not listed")
 }
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"regexp"
+)
+
+// Pattern is a regex that has to fully match the value, a nil pattern matches anything
+type Pattern struct {
+	raw   string
+	regex *regexp.Regexp
+}
+
+func NewPattern(raw string) (*Pattern, error) {
+	regex, err := regexp.Compile("^(?:" + raw + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile pattern '%v': %v", raw, err)
+	}
+	return &Pattern{raw: raw, regex: regex}, nil
+}
+
+func mustPattern(raw string) *Pattern {
+	pattern, err := NewPattern(raw)
+	if err != nil {
+		panic(err)
+	}
+	return pattern
+}
+
+func (pattern *Pattern) Matches(value string) bool {
+	return pattern == nil || pattern.regex.MatchString(value)
+}
+
+func (pattern *Pattern) UnmarshalYAML(value *yaml.Node) error {
+	var raw string
+	err := value.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	compiled, err := NewPattern(raw)
+	if err != nil {
+		return err
+	}
+	*pattern = *compiled
+	return nil
+}
+
+func (pattern *Pattern) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pattern.raw)
+}
+
+// Scope of an ignore rule, a rule with no scope applies to all clusters and namespaces
+type Scope struct {
+	Cluster   *Pattern `yaml:"cluster" json:"cluster,omitempty"`
+	Namespace *Pattern `yaml:"namespace" json:"namespace,omitempty"`
+}
+
+func (scope *Scope) matches(cluster string, namespace string) bool {
+	return scope.Cluster.Matches(cluster) && scope.Namespace.Matches(namespace)
+}
+
+type EventIgnoreRule struct {
+	Scope   `yaml:",inline"`
+	Reason  *Pattern `yaml:"reason" json:"reason,omitempty"`
+	Message *Pattern `yaml:"message" json:"message,omitempty"`
+	Kind    *Pattern `yaml:"kind" json:"kind,omitempty"`
+	Name    *Pattern `yaml:"name" json:"name,omitempty"`
+	Source  *Pattern `yaml:"source" json:"source,omitempty"`
+}
+
+type WaitingReasonIgnoreRule struct {
+	Scope  `yaml:",inline"`
+	Reason *Pattern `yaml:"reason" json:"reason,omitempty"`
+}
+
+type KindIgnoreRule struct {
+	Scope `yaml:",inline"`
+	Kind  *Pattern `yaml:"kind" json:"kind,omitempty"`
+}
+
+type IgnoreRules struct {
+	// when set, the builtin rules are not used
+	ReplaceDefaults      bool                       `yaml:"replaceDefaults" json:"replace_defaults"`
+	Events               []*EventIgnoreRule         `yaml:"events" json:"events"`
+	WaitingReasons       []*WaitingReasonIgnoreRule `yaml:"waitingReasons" json:"waiting_reasons"`
+	StandaloneEventKinds []*KindIgnoreRule          `yaml:"standaloneEventKinds" json:"standalone_event_kinds"`
+}
+
+func DefaultIgnoreRules() *IgnoreRules {
+	return &IgnoreRules{
+		Events: []*EventIgnoreRule{
+			{Reason: mustPattern("NodeSysctlChange|ContainerdStart|DockerStart|KubeletStart")},
+			{Reason: mustPattern("NodeNotReady"), Message: mustPattern("Node is not ready")},
+			{Message: mustPattern("(?s).*please apply your changes to the latest version and try again")},
+		},
+		WaitingReasons: []*WaitingReasonIgnoreRule{
+			{Reason: mustPattern("CrashLoopBackOff|Completed|ContainerCreating|PodInitializing")},
+		},
+		StandaloneEventKinds: []*KindIgnoreRule{
+			{Kind: mustPattern("Pod|Node|ReplicaSet")},
+		},
+	}
+}
+
+// LoadIgnoreRules reads ignore rules from a yaml file, merged with the builtin rules unless set to replace them
+func LoadIgnoreRules(filePath string) (*IgnoreRules, error) {
+	if filePath == "" {
+		return DefaultIgnoreRules(), nil
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ignore rules file from '%v': %v", filePath, err)
+	}
+	rules := &IgnoreRules{}
+	err = yaml.Unmarshal(content, rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize yaml from '%v': %v", filePath, err)
+	}
+	if rules.ReplaceDefaults {
+		return rules, nil
+	}
+	defaults := DefaultIgnoreRules()
+	rules.Events = append(defaults.Events, rules.Events...)
+	rules.WaitingReasons = append(defaults.WaitingReasons, rules.WaitingReasons...)
+	rules.StandaloneEventKinds = append(defaults.StandaloneEventKinds, rules.StandaloneEventKinds...)
+	return rules, nil
+}
+
+func (rules *IgnoreRules) IgnoreEvent(cluster string, namespace string, reason string, message string, kind string, name string, source string) bool {
+	if rules == nil {
+		return false
+	}
+	for _, rule := range rules.Events {
+		if rule.matches(cluster, namespace) &&
+			rule.Reason.Matches(reason) &&
+			rule.Message.Matches(message) &&
+			rule.Kind.Matches(kind) &&
+			rule.Name.Matches(name) &&
+			rule.Source.Matches(source) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rules *IgnoreRules) IgnoreWaitingReason(cluster string, namespace string, reason string) bool {
+	if rules == nil {
+		return false
+	}
+	for _, rule := range rules.WaitingReasons {
+		if rule.matches(cluster, namespace) && rule.Reason.Matches(reason) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rules *IgnoreRules) IgnoreStandaloneEventsOn(cluster string, namespace string, kind string) bool {
+	if rules == nil {
+		return false
+	}
+	for _, rule := range rules.StandaloneEventKinds {
+		if rule.matches(cluster, namespace) && rule.Kind.Matches(kind) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func writeIgnoreRules(t *testing.T, content string) string {
+	filePath := path.Join(t.TempDir(), "ignore.yaml")
+	err := ioutil.WriteFile(filePath, []byte(content), 0644)
+	require.Nil(t, err)
+	return filePath
+}
+
+func TestDefaultIgnoreRules(t *testing.T) {
+	rules := DefaultIgnoreRules()
+	require.True(t, rules.IgnoreEvent("", "", "KubeletStart", "", "Node", "node-1", "kubelet"))
+	require.True(t, rules.IgnoreEvent("", "", "NodeNotReady", "Node is not ready", "Node", "node-1", "node-controller"))
+	require.False(t, rules.IgnoreEvent("", "", "NodeNotReady", "Node is not ready due to disk", "Node", "node-1", "node-controller"))
+	require.True(t, rules.IgnoreEvent("", "ns", "FailedUpdate", "Operation cannot be fulfilled: please apply your changes to the latest version and try again", "Deployment", "app", ""))
+	require.False(t, rules.IgnoreEvent("", "ns", "BackOff", "Back-off restarting failed container", "Pod", "app", "kubelet"))
+
+	require.True(t, rules.IgnoreWaitingReason("", "ns", "CrashLoopBackOff"))
+	require.False(t, rules.IgnoreWaitingReason("", "ns", "ImagePullBackOff"))
+
+	require.True(t, rules.IgnoreStandaloneEventsOn("", "ns", "Pod"))
+	require.False(t, rules.IgnoreStandaloneEventsOn("", "ns", "Deployment"))
+}
+
+func TestLoadIgnoreRules(t *testing.T) {
+	// language=yaml
+	rules, err := LoadIgnoreRules(writeIgnoreRules(t, `
+events:
+  - cluster: prod-.*
+    namespace: monitoring
+    source: prometheus-operator
+    message: .*connection refused.*
+  - kind: CronJob
+    name: nightly-.*
+    reason: FailedNeedsStart
+waitingReasons:
+  - namespace: ci
+    reason: ImagePullBackOff
+standaloneEventKinds:
+  - cluster: staging
+    kind: Deployment
+`))
+	require.Nil(t, err)
+
+	require.True(t, rules.IgnoreEvent("prod-eu", "monitoring", "Failed", "dial tcp: connection refused", "Prometheus", "k8s", "prometheus-operator"))
+	require.False(t, rules.IgnoreEvent("dev", "monitoring", "Failed", "dial tcp: connection refused", "Prometheus", "k8s", "prometheus-operator"))
+	require.False(t, rules.IgnoreEvent("prod-eu", "default", "Failed", "dial tcp: connection refused", "Prometheus", "k8s", "prometheus-operator"))
+	require.True(t, rules.IgnoreEvent("any", "ns", "FailedNeedsStart", "", "CronJob", "nightly-backup", "cronjob-controller"))
+	require.False(t, rules.IgnoreEvent("any", "ns", "FailedNeedsStart", "", "CronJob", "hourly-backup", "cronjob-controller"))
+
+	require.True(t, rules.IgnoreWaitingReason("any", "ci", "ImagePullBackOff"))
+	require.False(t, rules.IgnoreWaitingReason("any", "prod", "ImagePullBackOff"))
+
+	require.True(t, rules.IgnoreStandaloneEventsOn("staging", "ns", "Deployment"))
+	require.False(t, rules.IgnoreStandaloneEventsOn("prod", "ns", "Deployment"))
+
+	// defaults are kept
+	require.True(t, rules.IgnoreEvent("", "", "KubeletStart", "", "Node", "node-1", "kubelet"))
+	require.True(t, rules.IgnoreStandaloneEventsOn("prod", "ns", "Pod"))
+}
+
+func TestLoadIgnoreRules_ReplaceDefaults(t *testing.T) {
+	// language=yaml
+	rules, err := LoadIgnoreRules(writeIgnoreRules(t, `
+replaceDefaults: true
+standaloneEventKinds:
+  - kind: Node
+`))
+	require.Nil(t, err)
+	require.False(t, rules.IgnoreEvent("", "", "KubeletStart", "", "Node", "node-1", "kubelet"))
+	require.False(t, rules.IgnoreWaitingReason("", "ns", "CrashLoopBackOff"))
+	require.False(t, rules.IgnoreStandaloneEventsOn("", "ns", "Pod"))
+	require.True(t, rules.IgnoreStandaloneEventsOn("", "", "Node"))
+}
+
+func TestLoadIgnoreRules_InvalidPattern(t *testing.T) {
+	_, err := LoadIgnoreRules(writeIgnoreRules(t, "events:\n  - reason: '('\n"))
+	require.NotNil(t, err)
+}
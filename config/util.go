@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"github.com/reallyliri/kubescout/alert"
 	"os"
 	"strings"
 )
@@ -33,3 +34,63 @@ func splitListFlag(flag string) []string {
 	}
 	return strings.Split(flag, ",")
 }
+
+// splitPatternsFlag splits a list of name patterns on commas, except for commas within a regex wrapped in slashes,
+// e.g. '/^x\d{2,4}$/,ns' is split to '/^x\d{2,4}$/' and 'ns'
+func splitPatternsFlag(flag string) []string {
+	if len(flag) == 0 {
+		return []string{}
+	}
+	var patterns []string
+	start := 0
+	inRegex := false
+	for i := 0; i < len(flag); i++ {
+		switch flag[i] {
+		case '/':
+			if !inRegex && strings.TrimSpace(flag[start:i]) == "" {
+				inRegex = true
+			} else if inRegex && endsPattern(flag[i+1:]) {
+				inRegex = false
+			}
+		case ',':
+			if !inRegex {
+				patterns = append(patterns, flag[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(patterns, flag[start:])
+}
+
+// endsPattern is whether the rest of a list flag starts with the next item or is over
+func endsPattern(rest string) bool {
+	rest = strings.TrimLeft(rest, " ")
+	return rest == "" || rest[0] == ','
+}
+
+func splitMapFlag(flag string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range splitListFlag(flag) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid key=value pair '%v'", pair)
+		}
+		m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return m, nil
+}
+
+func parseSeverityMapFlag(flag string) (map[string]alert.Severity, error) {
+	values, err := splitMapFlag(flag)
+	if err != nil {
+		return nil, err
+	}
+	severities := make(map[string]alert.Severity, len(values))
+	for key, value := range values {
+		severities[key], err = alert.ParseSeverity(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return severities, nil
+}
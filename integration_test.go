@@ -3,9 +3,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal"
 	"github.com/reallyliri/kubescout/internal/kubeclient"
 	"github.com/reallyliri/kubescout/internal/kubeconfig"
 	"github.com/reallyliri/kubescout/pkg"
@@ -84,7 +86,7 @@ func cleanupDefaultNamespace() error {
 }
 
 func verifyClusterInitialState(t *testing.T, client kubeclient.KubernetesClient) {
-	namespaces, err := client.GetNamespaces()
+	namespaces, err := client.GetNamespaces(context.Background())
 	require.Nil(t, err)
 	require.Equal(t, 4, len(namespaces))
 	require.Equal(t, "default", namespaces[0].Name)
@@ -92,13 +94,13 @@ func verifyClusterInitialState(t *testing.T, client kubeclient.KubernetesClient)
 	require.Equal(t, "kube-public", namespaces[2].Name)
 	require.Equal(t, "kube-system", namespaces[3].Name)
 
-	pods, err := client.GetPods("default")
+	pods, err := client.GetPods(context.Background(), "default")
 	require.Nil(t, err)
 	require.Equal(t, 0, len(pods))
 }
 
 func verifyClusterReadyForTest(t *testing.T, client kubeclient.KubernetesClient) {
-	namespaces, err := client.GetNamespaces()
+	namespaces, err := client.GetNamespaces(context.Background())
 	require.Nil(t, err)
 	require.Equal(t, 4, len(namespaces))
 	require.Equal(t, "default", namespaces[0].Name)
@@ -106,7 +108,7 @@ func verifyClusterReadyForTest(t *testing.T, client kubeclient.KubernetesClient)
 	require.Equal(t, "kube-public", namespaces[2].Name)
 	require.Equal(t, "kube-system", namespaces[3].Name)
 
-	pods, err := client.GetPods("default")
+	pods, err := client.GetPods(context.Background(), "default")
 	require.Nil(t, err)
 	require.Equal(t, 6, len(pods))
 }
@@ -144,9 +146,10 @@ func TestIntegration(t *testing.T) {
 	log.Infof("using store file at '%v'\n", storeFile.Name())
 	cfg.StoreFilePath = storeFile.Name()
 	cfg.MessagesDeduplicationDuration = time.Minute
-	cfg.IncludeNamespaces = []string{"default"}
+	cfg.IncludeNamespaces, err = internal.CompileNamePatterns([]string{"default"})
+	require.Nil(t, err)
 	cfg.OutputMode = "discard"
-	cfg.ContextName = contextName
+	cfg.ContextNames = []string{contextName}
 
 	err = verifyMinikubeRunning()
 	require.Nil(t, err)
@@ -0,0 +1,37 @@
+package diag
+
+import (
+	"github.com/reallyliri/kubescout/alert"
+	log "github.com/sirupsen/logrus"
+)
+
+const replicaSetsGroupVersion = "apps/v1"
+
+// apis which are not served by every cluster, and what kubescout does without them
+var optionalAPIs = []struct {
+	groupVersion string
+	without      string
+}{
+	{groupVersion: replicaSetsGroupVersion, without: "replica sets checks are disabled"},
+	{groupVersion: "events.k8s.io/v1", without: "events are listed with the core api"},
+}
+
+// detectCapabilities of the cluster, or nil if they could not be detected, in which case all checks run
+func (context *diagContext) detectCapabilities() *alert.ClusterCapabilities {
+	capabilities, err := context.client.GetCapabilities(context.ctx)
+	if err != nil {
+		log.Warnf("Failed to detect capabilities of cluster %v, running all checks: %v", context.clusterName(), err)
+		return nil
+	}
+
+	log.Debugf("Cluster %v serves %v", context.clusterName(), capabilities.GroupVersions)
+	if len(capabilities.CRDs) > 0 {
+		log.Debugf("Cluster %v has CRDs %v", context.clusterName(), capabilities.CRDs)
+	}
+	for _, api := range optionalAPIs {
+		if !capabilities.Serves(api.groupVersion) {
+			log.Infof("Cluster %v does not serve %v, %v", context.clusterName(), api.groupVersion, api.without)
+		}
+	}
+	return capabilities
+}
@@ -1,36 +1,53 @@
 package diag
 
 import (
+	"context"
+	"fmt"
 	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/config"
 	"github.com/reallyliri/kubescout/internal"
 	"github.com/reallyliri/kubescout/internal/dedup"
 	"github.com/reallyliri/kubescout/internal/kubeclient"
+	"github.com/reallyliri/kubescout/internal/rules"
 	"github.com/reallyliri/kubescout/internal/store"
 	log "github.com/sirupsen/logrus"
 	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sync"
 	"time"
 )
 
 type diagContext struct {
-	config                *config.Config
-	store                 *store.ClusterStore
-	now                   time.Time
-	includedNamespacesSet map[string]bool
-	excludedNamespacesSet map[string]bool
-	client                kubeclient.KubernetesClient
-	statesByName          map[store.EntityName]*entityState
-	eventsByName          map[store.EntityName][]*eventState
-}
-
-var excludeStandaloneEventsOnKinds = map[string]bool{
-	"Pod":        true,
-	"Node":       true,
-	"ReplicaSet": true,
+	ctx                 context.Context
+	config              *config.Config
+	store               *store.ClusterStore
+	now                 time.Time
+	eventsSince         time.Time
+	includedNamespaces  *internal.NamePatterns
+	excludedNamespaces  *internal.NamePatterns
+	namespaceSelector   labels.Selector
+	client              kubeclient.KubernetesClient
+	capabilities        *alert.ClusterCapabilities
+	rules               *rules.RuleSet
+	statesByName        map[store.EntityName]*entityState
+	eventsByName        map[store.EntityName][]*eventState
+	namespaceOverrides  map[string]overrides
+	replicaSetOverrides map[string]overrides
+	workloadsOverrides  map[string]overrides
+	// unhealthy entities of this run, the rest of the firing entities within the scanned scope are resolved
+	firingEntities map[store.EntityName]bool
+	scanned        store.Scope
 }
 
 const graceTimeForEventSinceEntityCreation = time.Second * time.Duration(5)
 
+// events seen shortly before the last run are listed again, to cover clock skew between kubescout and the cluster
+const eventsSinceLastRunOverlap = time.Minute * time.Duration(5)
+
+// logs are read since shortly before the problem started, as a crashing container writes its last lines before it exits
+const logsLookbackBeforeProblem = time.Minute * time.Duration(5)
+
 func testContext(now time.Time) *diagContext {
 	return testContextWithClient(now, nil)
 }
@@ -42,11 +59,17 @@ func testContextWithClient(now time.Time, client kubeclient.KubernetesClient) *d
 	}
 	log.SetLevel(log.DebugLevel)
 	return &diagContext{
-		config:       cfg,
-		client:       client,
-		statesByName: map[store.EntityName]*entityState{},
-		eventsByName: map[store.EntityName][]*eventState{},
-		now:          now,
+		ctx:                 context.Background(),
+		config:              cfg,
+		client:              client,
+		statesByName:        map[store.EntityName]*entityState{},
+		eventsByName:        map[store.EntityName][]*eventState{},
+		namespaceOverrides:  map[string]overrides{},
+		replicaSetOverrides: map[string]overrides{},
+		workloadsOverrides:  map[string]overrides{},
+		firingEntities:      map[store.EntityName]bool{},
+		scanned:             store.Scope{},
+		now:                 now,
 	}
 }
 
@@ -76,9 +99,20 @@ func (context *diagContext) handleEntityState(state *entityState, events []*even
 		log.Trace(state.String())
 		return
 	}
+	if context.isIgnored(state) {
+		log.Debugf("[IGNORED] %v", state)
+		context.store.Forget(state.name)
+		return
+	}
+	context.firingEntities[state.name] = true
 
+	cluster := context.clusterInfo()
 	entityAlert := &alert.EntityAlert{
 		ClusterName:         context.store.Cluster,
+		ClusterAlias:        cluster.Alias,
+		ClusterMetadata:     cluster.Metadata,
+		Fingerprint:         context.fingerprint(state.name, state.messages),
+		Status:              alert.StatusFiring,
 		Namespace:           state.name.Namespace,
 		Name:                state.name.Name,
 		Kind:                state.name.Kind,
@@ -90,7 +124,7 @@ func (context *diagContext) handleEntityState(state *entityState, events []*even
 	}
 
 	for _, message := range state.messages {
-		stored := context.store.TryAdd(state.name, message, context.now)
+		stored := context.store.TryAddWithDedupDuration(state.name, message, context.now, context.dedupDuration(state))
 		if stored {
 			entityAlert.Messages = append(entityAlert.Messages, dedup.CleanTemporal(message))
 		}
@@ -104,7 +138,7 @@ func (context *diagContext) handleEntityState(state *entityState, events []*even
 	}
 
 	for _, event := range events {
-		stored := context.store.TryAdd(state.name, event.message, context.now)
+		stored := context.store.TryAddWithDedupDuration(state.name, event.message, context.now, context.dedupDuration(state))
 		if stored {
 			entityAlert.Events = append(entityAlert.Events, dedup.CleanTemporal(event.message))
 			setMinTimestamp(&entityAlert.Timestamp, event.firstTimestamp)
@@ -112,20 +146,67 @@ func (context *diagContext) handleEntityState(state *entityState, events []*even
 	}
 
 	log.Info(state.String())
+	entityAlert.Severity = context.alertSeverity(state.name, state.severityByReason, events)
+	if state.overrides.severity != "" {
+		entityAlert.Severity = state.overrides.severity
+	}
 	entityAlert.LogsByContainerName = state.logsCollections
+	entityAlert.LikelyCause = state.likelyCause
 	context.store.Alerts = append(context.store.Alerts, entityAlert)
+	context.store.Fire(state.name, entityAlert)
+}
+
+// handleResolved reports the entities which were firing, and are now healthy or gone.
+// An alert is resolved per fingerprint the entity fired with, so downstream systems close each incident they opened.
+func (context *diagContext) handleResolved() {
+	for _, firing := range context.store.ResolveExcept(context.scanned, context.firingEntities) {
+		name := firing.Entity
+		var message string
+		if _, found := context.statesByName[name]; found {
+			message = fmt.Sprintf("%v %v recovered, after being un-healthy for %v", name.Kind, name.Name, formatElapsed(firing.Since, context.now))
+		} else {
+			message = fmt.Sprintf("%v %v is gone, after being un-healthy for %v", name.Kind, name.Name, formatElapsed(firing.Since, context.now))
+		}
+		log.Infof("[RESOLVED] %v", message)
+
+		cluster := context.clusterInfo()
+		resolvedAt := context.now
+		for _, fingerprint := range firing.Fingerprints {
+			context.store.Alerts = append(context.store.Alerts, &alert.EntityAlert{
+				ClusterName:         context.store.Cluster,
+				ClusterAlias:        cluster.Alias,
+				ClusterMetadata:     cluster.Metadata,
+				Fingerprint:         fingerprint,
+				Status:              alert.StatusResolved,
+				Namespace:           name.Namespace,
+				Name:                name.Name,
+				Kind:                name.Kind,
+				Severity:            firing.Severity,
+				Messages:            []string{message},
+				Events:              []string{},
+				LogsByContainerName: map[string]string{},
+				Timestamp:           firing.Since,
+				ResolvedAt:          &resolvedAt,
+			})
+		}
+	}
 }
 
 func (context *diagContext) handleStandaloneEvents(name store.EntityName, events []*eventState) {
 
 	events = unhealthyEvents(nil, events)
 
-	if excludeStandaloneEventsOnKinds[name.Kind] {
+	if context.config.IgnoreRules.IgnoreStandaloneEventsOn(context.clusterName(), name.Namespace, name.Kind) {
 		return
 	}
 
+	cluster := context.clusterInfo()
 	entityAlert := &alert.EntityAlert{
 		ClusterName:         context.store.Cluster,
+		ClusterAlias:        cluster.Alias,
+		ClusterMetadata:     cluster.Metadata,
+		Fingerprint:         context.fingerprint(name, nil),
+		Status:              alert.StatusFiring,
 		Namespace:           name.Namespace,
 		Name:                name.Name,
 		Kind:                name.Kind,
@@ -144,34 +225,117 @@ func (context *diagContext) handleStandaloneEvents(name store.EntityName, events
 	}
 
 	if len(entityAlert.Events) > 0 {
+		entityAlert.Severity = context.alertSeverity(name, nil, events)
 		context.store.Alerts = append(context.store.Alerts, entityAlert)
 	}
 }
 
-func (context *diagContext) isNamespaceRelevant(namespaceName string) bool {
-	if len(context.includedNamespacesSet) > 0 && !context.includedNamespacesSet[namespaceName] {
+// handleIncompleteScan reports that alerts of objects which were not scanned in time are missing
+func (context *diagContext) handleIncompleteScan(cause error) {
+	name := store.EntityName{
+		Kind: "Cluster",
+		Name: context.clusterName(),
+	}
+	message := fmt.Sprintf("Scan incomplete: %v, alerts of objects that were not scanned are missing", cause)
+	if !context.store.TryAdd(name, message, context.now) {
+		return
+	}
+	cluster := context.clusterInfo()
+	context.store.Alerts = append(context.store.Alerts, &alert.EntityAlert{
+		ClusterName:         context.store.Cluster,
+		ClusterAlias:        cluster.Alias,
+		ClusterMetadata:     cluster.Metadata,
+		Fingerprint:         context.fingerprint(name, []string{message}),
+		Status:              alert.StatusFiring,
+		Name:                name.Name,
+		Kind:                name.Kind,
+		Messages:            []string{message},
+		Events:              []string{},
+		LogsByContainerName: map[string]string{},
+		Timestamp:           context.now,
+		Severity:            alert.SeverityWarning,
+	})
+}
+
+// DiagnoseIncompleteScan adds an alert to the cluster store reporting that the cluster could not be scanned,
+// e.g. when a watched cluster is not reachable
+func DiagnoseIncompleteScan(cfg *config.Config, clusterStore *store.ClusterStore, now time.Time, cause error) {
+	context := diagContext{
+		config: cfg,
+		store:  clusterStore,
+		now:    now,
+	}
+	context.handleIncompleteScan(cause)
+}
+
+func (context *diagContext) clusterName() string {
+	if context.store == nil {
+		return ""
+	}
+	return context.store.Cluster
+}
+
+// fingerprint of the entity issue, of all its messages rather than only those not deduplicated
+func (context *diagContext) fingerprint(name store.EntityName, messages []string) string {
+	normalizedMessages := make([]string, 0, len(messages))
+	for _, message := range messages {
+		normalizedMessages = append(normalizedMessages, dedup.NormalizeTemporal(message))
+	}
+	return alert.Fingerprint(context.clusterName(), name.Kind, name.Namespace, name.Name, normalizedMessages)
+}
+
+// clusterInfo is the configured alias and metadata of the cluster
+func (context *diagContext) clusterInfo() *config.ClusterInfo {
+	return context.config.ClustersInfo.Get(context.clusterName())
+}
+
+func (context *diagContext) isNamespaceRelevant(namespace *v1.Namespace) bool {
+	if context.includedNamespaces != nil && !context.includedNamespaces.Empty() && !context.includedNamespaces.Matches(namespace.Name) {
+		return false
+	}
+	if context.excludedNamespaces != nil && context.excludedNamespaces.Matches(namespace.Name) {
 		return false
 	}
-	if len(context.excludedNamespacesSet) > 0 && context.excludedNamespacesSet[namespaceName] {
+	if context.namespaceSelector != nil && !context.namespaceSelector.Matches(labels.Set(namespace.Labels)) {
 		return false
 	}
 	return true
 }
 
-func DiagnoseCluster(client kubeclient.KubernetesClient, cfg *config.Config, clusterStore *store.ClusterStore, now time.Time) (aggregatedError error) {
+// DiagnoseCluster adds the cluster alerts to its store. If ctx is done before all objects were collected,
+// the collected objects are still diagnosed and an alert reports the scan as incomplete.
+func DiagnoseCluster(ctx context.Context, client kubeclient.KubernetesClient, cfg *config.Config, clusterStore *store.ClusterStore, now time.Time) (aggregatedError error) {
+	eventsSince := clusterStore.LastRunAt
+	if !eventsSince.IsZero() {
+		eventsSince = eventsSince.Add(-eventsSinceLastRunOverlap)
+	}
+
 	context := diagContext{
-		config:                cfg,
-		store:                 clusterStore,
-		now:                   now,
-		includedNamespacesSet: internal.ToBoolMap(cfg.IncludeNamespaces),
-		excludedNamespacesSet: internal.ToBoolMap(cfg.ExcludeNamespaces),
-		client:                client,
-		statesByName:          map[store.EntityName]*entityState{},
-		eventsByName:          map[store.EntityName][]*eventState{},
+		ctx:                 ctx,
+		eventsSince:         eventsSince,
+		config:              cfg,
+		store:               clusterStore,
+		now:                 now,
+		includedNamespaces:  cfg.IncludeNamespaces,
+		excludedNamespaces:  cfg.ExcludeNamespaces,
+		namespaceSelector:   cfg.NamespaceSelector,
+		client:              client,
+		rules:               cfg.Rules,
+		statesByName:        map[store.EntityName]*entityState{},
+		eventsByName:        map[store.EntityName][]*eventState{},
+		namespaceOverrides:  map[string]overrides{},
+		replicaSetOverrides: map[string]overrides{},
+		workloadsOverrides:  map[string]overrides{},
+		firingEntities:      map[store.EntityName]bool{},
+		scanned:             store.Scope{},
 	}
 
+	context.capabilities = context.detectCapabilities()
+	clusterStore.Capabilities = context.capabilities
+
 	err := context.collectStates()
-	if err != nil {
+	incomplete := ctx.Err() != nil
+	if err != nil && !incomplete {
 		return err
 	}
 
@@ -180,6 +344,22 @@ func DiagnoseCluster(client kubeclient.KubernetesClient, cfg *config.Config, clu
 		delete(context.eventsByName, name)
 	}
 
+	if incomplete {
+		log.Warnf("Scan of cluster %v is incomplete: %v", context.clusterName(), ctx.Err())
+		context.handleIncompleteScan(ctx.Err())
+	} else {
+		// entities which were not scanned are not known to be healthy
+		context.handleResolved()
+		// events of objects which were not scanned are listed again on the next run
+		clusterStore.LastRunAt = now
+	}
+
+	// events carry no labels of their involved objects, so they cannot be scoped by the workload selector
+	if cfg.WorkloadSelector != "" {
+		log.Debugf("Skipping %v standalone events since a workload selector is set", len(context.eventsByName))
+		return
+	}
+
 	for entityName, states := range context.eventsByName {
 		context.handleStandaloneEvents(entityName, states)
 	}
@@ -189,72 +369,184 @@ func DiagnoseCluster(client kubeclient.KubernetesClient, cfg *config.Config, clu
 
 func (context *diagContext) collectStates() error {
 	client := context.client
-	namespaces, err := client.GetNamespaces()
+	namespaces, namespacesListed, err := context.listNamespaces()
 	if err != nil {
 		return err
 	}
 
 	var aggregatedError error
 
-	log.Debugf("Discovered %v namespaces", len(namespaces))
+	var relevantNamespaces []v1.Namespace
 	for _, namespace := range namespaces {
-		namespaceName := namespace.Name
-		if !context.isNamespaceRelevant(namespaceName) {
-			continue
+		if context.isNamespaceRelevant(&namespace) {
+			relevantNamespaces = append(relevantNamespaces, namespace)
 		}
+	}
+	log.Debugf("Discovered %v namespaces, %v of them relevant", len(namespaces), len(relevantNamespaces))
 
-		events, err := client.GetEvents(namespaceName)
-		if err != nil {
-			aggregatedError = multierr.Append(aggregatedError, err)
-		} else {
-			log.Debugf("Discovered %v events in namespace %v", len(events), namespaceName)
-			for _, event := range events {
-				_, err = context.eventState(&event)
-				if err != nil {
-					aggregatedError = multierr.Append(aggregatedError, err)
-				}
+	// listing across all namespaces is only worthwhile when most of them are scanned
+	var lists *clusterWideLists
+	if namespacesListed && len(relevantNamespaces) > 1 && len(relevantNamespaces)*2 >= len(namespaces) {
+		lists = context.listClusterWide()
+	}
+
+	// namespaces are collected concurrently, each into its own context, and merged in their listing order
+	// so the outcome does not depend on scheduling
+	namespaceContexts := make([]*diagContext, len(relevantNamespaces))
+	namespaceErrors := make([]error, len(relevantNamespaces))
+	workersCount := context.config.NamespaceConcurrency
+	if workersCount > len(relevantNamespaces) {
+		workersCount = len(relevantNamespaces)
+	}
+	if workersCount < 1 {
+		workersCount = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workersCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				namespaceContext := context.namespaceContext()
+				namespaceErrors[index] = namespaceContext.collectNamespace(&relevantNamespaces[index], lists)
+				namespaceContexts[index] = namespaceContext
 			}
-		}
+		}()
+	}
+	for index := range relevantNamespaces {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
 
-		pods, err := client.GetPods(namespaceName)
-		if err != nil {
-			aggregatedError = multierr.Append(aggregatedError, err)
+	for index, namespaceContext := range namespaceContexts {
+		context.merge(namespaceContext)
+		aggregatedError = multierr.Append(aggregatedError, namespaceErrors[index])
+	}
+
+	nodes, err := client.GetNodes(context.ctx)
+	if err != nil {
+		if kubeclient.IsForbidden(err) {
+			log.Warnf("Not permitted to list nodes, skipping nodes diagnosis: %v", err)
 		} else {
-			log.Debugf("Discovered %v pods in namespace %v", len(pods), namespaceName)
-			for _, pod := range pods {
-				_, err = context.podState(&pod)
-				if err != nil {
-					aggregatedError = multierr.Append(aggregatedError, err)
-				}
+			aggregatedError = multierr.Append(aggregatedError, err)
+		}
+	} else {
+		log.Debugf("Discovered %v nodes", len(nodes))
+		context.scanned.Add("", "Node")
+		for _, node := range nodes {
+			state, err := context.nodeState(&node, false)
+			if err != nil {
+				aggregatedError = multierr.Append(aggregatedError, err)
+			}
+			err = context.applyRules(state, node.Labels, &node)
+			if err != nil {
+				aggregatedError = multierr.Append(aggregatedError, err)
 			}
 		}
+	}
 
-		replicaSets, err := client.GetReplicaSets(namespaceName)
-		if err != nil {
-			aggregatedError = multierr.Append(aggregatedError, err)
-		} else {
-			log.Debugf("Discovered %v replica sets in namespace %v", len(replicaSets), namespaceName)
-			for _, replicaSet := range replicaSets {
-				_, err = context.replicaSetState(&replicaSet)
-				if err != nil {
-					aggregatedError = multierr.Append(aggregatedError, err)
-				}
+	return aggregatedError
+}
+
+// namespaceContext creates a context to collect a single namespace into, to be merged back after collection
+func (context *diagContext) namespaceContext() *diagContext {
+	namespaceContext := *context
+	namespaceContext.statesByName = map[store.EntityName]*entityState{}
+	namespaceContext.eventsByName = map[store.EntityName][]*eventState{}
+	namespaceContext.namespaceOverrides = map[string]overrides{}
+	namespaceContext.replicaSetOverrides = map[string]overrides{}
+	namespaceContext.workloadsOverrides = map[string]overrides{}
+	namespaceContext.scanned = store.Scope{}
+	return &namespaceContext
+}
+
+func (context *diagContext) merge(namespaceContext *diagContext) {
+	for name, state := range namespaceContext.statesByName {
+		context.statesByName[name] = state
+	}
+	// events of cluster scoped entities, such as nodes, can be listed in several namespaces
+	for name, events := range namespaceContext.eventsByName {
+		context.eventsByName[name] = append(context.eventsByName[name], events...)
+	}
+	for name, namespaceOverrides := range namespaceContext.namespaceOverrides {
+		context.namespaceOverrides[name] = namespaceOverrides
+	}
+	for name, replicaSetOverrides := range namespaceContext.replicaSetOverrides {
+		context.replicaSetOverrides[name] = replicaSetOverrides
+	}
+	context.scanned.Merge(namespaceContext.scanned)
+}
+
+func (context *diagContext) collectNamespace(namespace *v1.Namespace, lists *clusterWideLists) (aggregatedError error) {
+	namespaceName := namespace.Name
+
+	namespaceState := context.getOrAddState("", "Namespace", namespaceName, namespace.CreationTimestamp.Time)
+	namespaceState.overrides = parseOverrides("Namespace", namespaceName, namespace.Annotations)
+	if context.isIgnored(namespaceState) {
+		log.Debugf("Skipping namespace %v which is annotated to be ignored", namespaceName)
+		return nil
+	}
+	context.namespaceOverrides[namespaceName] = namespaceState.overrides
+	context.scanned.Add(namespaceName, "Namespace")
+
+	err := context.applyRules(namespaceState, namespace.Labels, namespace)
+	if err != nil {
+		aggregatedError = multierr.Append(aggregatedError, err)
+	}
+
+	events, err := context.getEvents(namespaceName, lists)
+	if err != nil {
+		aggregatedError = multierr.Append(aggregatedError, err)
+	} else {
+		log.Debugf("Discovered %v events in namespace %v", len(events), namespaceName)
+		for _, event := range events {
+			_, err = context.eventState(&event)
+			if err != nil {
+				aggregatedError = multierr.Append(aggregatedError, err)
 			}
 		}
 	}
 
-	nodes, err := client.GetNodes()
+	// replica sets are collected before pods, so their overrides apply to the pods they own
+	replicaSets, err := context.getReplicaSets(namespaceName, lists)
 	if err != nil {
 		aggregatedError = multierr.Append(aggregatedError, err)
 	} else {
-		log.Debugf("Discovered %v nodes", len(nodes))
-		for _, node := range nodes {
-			_, err = context.nodeState(&node, false)
+		log.Debugf("Discovered %v replica sets in namespace %v", len(replicaSets), namespaceName)
+		// replica sets of a cluster not serving their api are not listed, and not known to be healthy
+		if context.capabilities.Serves(replicaSetsGroupVersion) {
+			context.scanned.Add(namespaceName, "ReplicaSet")
+		}
+		for _, replicaSet := range replicaSets {
+			state, err := context.replicaSetState(&replicaSet)
+			if err != nil {
+				aggregatedError = multierr.Append(aggregatedError, err)
+			}
+			err = context.applyRules(state, replicaSet.Labels, &replicaSet)
 			if err != nil {
 				aggregatedError = multierr.Append(aggregatedError, err)
 			}
 		}
 	}
 
-	return aggregatedError
+	pods, err := context.getPods(namespaceName, lists)
+	if err != nil {
+		aggregatedError = multierr.Append(aggregatedError, err)
+	} else {
+		log.Debugf("Discovered %v pods in namespace %v", len(pods), namespaceName)
+		context.scanned.Add(namespaceName, "Pod")
+		for _, pod := range pods {
+			state, err := context.podState(&pod)
+			if err != nil {
+				aggregatedError = multierr.Append(aggregatedError, err)
+			}
+			err = context.applyRules(state, pod.Labels, &pod)
+			if err != nil {
+				aggregatedError = multierr.Append(aggregatedError, err)
+			}
+		}
+	}
+	return
 }
@@ -1,12 +1,19 @@
 package diag
 
 import (
+	"context"
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal"
 	"github.com/reallyliri/kubescout/internal/kubeclient"
+	"github.com/reallyliri/kubescout/internal/rules"
 	"github.com/reallyliri/kubescout/internal/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"path"
 	"runtime"
 	"sort"
@@ -31,6 +38,7 @@ func setUp(t *testing.T, resourcesDirectoryName string) (*config.Config, kubecli
 	cfg.MessagesDeduplicationDuration = time.Hour
 
 	client, err := kubeclient.CreateMockClient(
+		cfg,
 		path.Join(apiResponsesDirectoryPath, resourcesDirectoryName, "nodes.json"),
 		path.Join(apiResponsesDirectoryPath, resourcesDirectoryName, "ns.json"),
 		path.Join(apiResponsesDirectoryPath, resourcesDirectoryName, "pods.json"),
@@ -51,7 +59,7 @@ func Test_Diagnose(t *testing.T) {
 	require.Nil(t, err)
 	clusterStore := stor.GetClusterStore("diag-test", now)
 	assert.Equal(t, 0, len(clusterStore.Alerts))
-	err = DiagnoseCluster(client, cfg, clusterStore, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
 	require.Nil(t, err)
 	err = stor.Flush(now)
 	require.Nil(t, err)
@@ -60,38 +68,13 @@ func Test_Diagnose(t *testing.T) {
 	sort.Sort(alerts)
 	assert.Equal(t, 5, len(alerts))
 
+	// crash looping pods are critical by default, and come first
 	i := 0
 	assert.Equal(t, clusterStore.Cluster, alerts[i].ClusterName)
 	assert.Equal(t, "default", alerts[i].Namespace)
-	assert.Equal(t, "test-2-broken-image-7cbf974df9-4jv8f", alerts[i].Name)
-	assert.Equal(t, "Pod", alerts[i].Kind)
-	assert.Equal(t, 1, len(alerts[i].Messages))
-	assert.Equal(t, "Container test-2-broken-image still waiting due to ImagePullBackOff: Back-off pulling image \"nginx:l4t3st\"", alerts[i].Messages[0])
-	assert.Equal(t, 2, len(alerts[i].Events))
-	assert.Equal(t, `Event by kubelet: Failed x4 since 17 Oct 21 14:15 UTC, 4 minutes ago (last seen 2 minutes ago):
-	Failed to pull image "nginx:l4t3st": rpc error: code = Unknown desc = Error response from daemon: manifest for nginx:l4t3st not found: manifest unknown: manifest unknown`, alerts[i].Events[0])
-	assert.Equal(t, `Event by kubelet: Failed x4 since 17 Oct 21 14:15 UTC, 4 minutes ago (last seen 2 minutes ago):
-	Error: ErrImagePull`, alerts[i].Events[1])
-	assert.Equal(t, 1, len(alerts[i].LogsByContainerName))
-	assert.Equal(t, "default/test-2-broken-image-7cbf974df9-4jv8f/test-2-broken-image/logs", alerts[i].LogsByContainerName["test-2-broken-image"])
-
-	i++
-	assert.Equal(t, clusterStore.Cluster, alerts[i].ClusterName)
-	assert.Equal(t, "default", alerts[i].Namespace)
-	assert.Equal(t, "test-3-excessive-resources-699d58f55f-q9z65", alerts[i].Name)
-	assert.Equal(t, "Pod", alerts[i].Kind)
-	assert.Equal(t, 1, len(alerts[i].Messages))
-	assert.Equal(t, "Unschedulable: 0/1 nodes are available: 1 Insufficient memory. (last transition: 4 minutes ago)", alerts[i].Messages[0])
-	assert.Equal(t, 1, len(alerts[i].Events))
-	assert.Equal(t, `Event by default-scheduler: FailedScheduling since 17 Oct 21 14:16 UTC, 3 minutes ago (last seen 2 minutes ago):
-	0/1 nodes are available: 1 Insufficient memory.`, alerts[i].Events[0])
-	assert.Equal(t, 0, len(alerts[i].LogsByContainerName))
-
-	i++
-	assert.Equal(t, clusterStore.Cluster, alerts[i].ClusterName)
-	assert.Equal(t, "default", alerts[i].Namespace)
 	assert.Equal(t, "test-4-crashlooping-dbdd84589-8m7kj", alerts[i].Name)
 	assert.Equal(t, "Pod", alerts[i].Kind)
+	assert.Equal(t, alert.SeverityCritical, alerts[i].Severity)
 	assert.Equal(t, 1, len(alerts[i].Messages))
 	assert.Equal(t, "Container test-4-crashlooping is in CrashLoopBackOff: restarted 4 times, last exit due to Error (exit code 1)", alerts[i].Messages[0])
 	assert.Equal(t, 1, len(alerts[i].Events))
@@ -105,6 +88,7 @@ func Test_Diagnose(t *testing.T) {
 	assert.Equal(t, "default", alerts[i].Namespace)
 	assert.Equal(t, "test-5-completed-757685986-qxbqp", alerts[i].Name)
 	assert.Equal(t, "Pod", alerts[i].Kind)
+	assert.Equal(t, alert.SeverityCritical, alerts[i].Severity)
 	assert.Equal(t, 1, len(alerts[i].Messages))
 	assert.Equal(t, "Container test-5-completed is in CrashLoopBackOff: restarted 4 times, last exit due to Completed (exit code 0)", alerts[i].Messages[0])
 	assert.Equal(t, 1, len(alerts[i].Events))
@@ -118,6 +102,7 @@ func Test_Diagnose(t *testing.T) {
 	assert.Equal(t, "default", alerts[i].Namespace)
 	assert.Equal(t, "test-6-crashlooping-init-644545f5b7-l468n", alerts[i].Name)
 	assert.Equal(t, "Pod", alerts[i].Kind)
+	assert.Equal(t, alert.SeverityCritical, alerts[i].Severity)
 	assert.Equal(t, 1, len(alerts[i].Messages))
 	assert.Equal(t, "Container test-6-crashlooping-init-container (init) is in CrashLoopBackOff: restarted 4 times, last exit due to Error (exit code 1)", alerts[i].Messages[0])
 	assert.Equal(t, 1, len(alerts[i].Events))
@@ -125,6 +110,35 @@ func Test_Diagnose(t *testing.T) {
 	Back-off restarting failed container`, alerts[i].Events[0])
 	assert.Equal(t, 1, len(alerts[i].LogsByContainerName))
 	assert.Equal(t, "default/test-6-crashlooping-init-644545f5b7-l468n/test-6-crashlooping-init-container/logs", alerts[i].LogsByContainerName["test-6-crashlooping-init-container"])
+
+	i++
+	assert.Equal(t, clusterStore.Cluster, alerts[i].ClusterName)
+	assert.Equal(t, "default", alerts[i].Namespace)
+	assert.Equal(t, "test-2-broken-image-7cbf974df9-4jv8f", alerts[i].Name)
+	assert.Equal(t, "Pod", alerts[i].Kind)
+	assert.Equal(t, alert.SeverityWarning, alerts[i].Severity)
+	assert.Equal(t, 1, len(alerts[i].Messages))
+	assert.Equal(t, "Container test-2-broken-image still waiting due to ImagePullBackOff: Back-off pulling image \"nginx:l4t3st\"", alerts[i].Messages[0])
+	assert.Equal(t, 2, len(alerts[i].Events))
+	assert.Equal(t, `Event by kubelet: Failed x4 since 17 Oct 21 14:15 UTC, 4 minutes ago (last seen 2 minutes ago):
+	Failed to pull image "nginx:l4t3st": rpc error: code = Unknown desc = Error response from daemon: manifest for nginx:l4t3st not found: manifest unknown: manifest unknown`, alerts[i].Events[0])
+	assert.Equal(t, `Event by kubelet: Failed x4 since 17 Oct 21 14:15 UTC, 4 minutes ago (last seen 2 minutes ago):
+	Error: ErrImagePull`, alerts[i].Events[1])
+	assert.Equal(t, 1, len(alerts[i].LogsByContainerName))
+	assert.Equal(t, "default/test-2-broken-image-7cbf974df9-4jv8f/test-2-broken-image/logs", alerts[i].LogsByContainerName["test-2-broken-image"])
+
+	i++
+	assert.Equal(t, clusterStore.Cluster, alerts[i].ClusterName)
+	assert.Equal(t, "default", alerts[i].Namespace)
+	assert.Equal(t, "test-3-excessive-resources-699d58f55f-q9z65", alerts[i].Name)
+	assert.Equal(t, "Pod", alerts[i].Kind)
+	assert.Equal(t, alert.SeverityWarning, alerts[i].Severity)
+	assert.Equal(t, 1, len(alerts[i].Messages))
+	assert.Equal(t, "Unschedulable: 0/1 nodes are available: 1 Insufficient memory. (last transition: 4 minutes ago)", alerts[i].Messages[0])
+	assert.Equal(t, 1, len(alerts[i].Events))
+	assert.Equal(t, `Event by default-scheduler: FailedScheduling since 17 Oct 21 14:16 UTC, 3 minutes ago (last seen 2 minutes ago):
+	0/1 nodes are available: 1 Insufficient memory.`, alerts[i].Events[0])
+	assert.Equal(t, 0, len(alerts[i].LogsByContainerName))
 }
 
 func Test_Diagnose_RepeatingCallAfterShortTime(t *testing.T) {
@@ -138,7 +152,7 @@ func Test_Diagnose_RepeatingCallAfterShortTime(t *testing.T) {
 	require.Nil(t, err)
 	clusterStore1 := store1.GetClusterStore(clusterName, now)
 	assert.Equal(t, 0, len(clusterStore1.Alerts))
-	err = DiagnoseCluster(client, cfg, clusterStore1, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore1, now)
 	require.Nil(t, err)
 	assert.Equal(t, 5, len(clusterStore1.Alerts))
 	err = store1.Flush(now)
@@ -151,7 +165,7 @@ func Test_Diagnose_RepeatingCallAfterShortTime(t *testing.T) {
 
 	clusterStore2 := store2.GetClusterStore(clusterName, nearFuture)
 
-	err = DiagnoseCluster(client, cfg, clusterStore2, nearFuture)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore2, nearFuture)
 	require.Nil(t, err)
 	assert.Equal(t, 0, len(clusterStore2.Alerts))
 	err = store2.Flush(nearFuture)
@@ -169,7 +183,7 @@ func Test_Diagnose_RepeatingCallAfterLongTime(t *testing.T) {
 	require.Nil(t, err)
 	clusterStore1 := store1.GetClusterStore(clusterName, now)
 	assert.Equal(t, 0, len(clusterStore1.Alerts))
-	err = DiagnoseCluster(client, cfg, clusterStore1, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore1, now)
 	require.Nil(t, err)
 	assert.Equal(t, 5, len(clusterStore1.Alerts))
 	err = store1.Flush(now)
@@ -182,9 +196,15 @@ func Test_Diagnose_RepeatingCallAfterLongTime(t *testing.T) {
 
 	clusterStore2 := store2.GetClusterStore(clusterName, farFuture)
 
-	err = DiagnoseCluster(client, cfg, clusterStore2, farFuture)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore2, farFuture)
 	require.Nil(t, err)
 	assert.Equal(t, 5, len(clusterStore2.Alerts))
+	sort.Sort(clusterStore1.Alerts)
+	sort.Sort(clusterStore2.Alerts)
+	for i, entityAlert := range clusterStore2.Alerts {
+		assert.NotEmpty(t, entityAlert.Fingerprint)
+		assert.Equal(t, clusterStore1.Alerts[i].Fingerprint, entityAlert.Fingerprint, "the same issues are reported with the same fingerprints")
+	}
 	err = store2.Flush(farFuture)
 	require.Nil(t, err)
 }
@@ -199,7 +219,7 @@ func Test_Diagnose_EventsDeduplicationOnLivenessCheckFail(t *testing.T) {
 	require.Nil(t, err)
 	clusterStore := stor.GetClusterStore(clusterName, now)
 	assert.Equal(t, 0, len(clusterStore.Alerts))
-	err = DiagnoseCluster(client, cfg, clusterStore, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
 	require.Nil(t, err)
 
 	alerts := clusterStore.Alerts
@@ -233,7 +253,7 @@ func Test_Diagnose_EventsDeduplicationOnLivenessCheckFail(t *testing.T) {
 	now = now.Add(time.Minute * time.Duration(17))
 	clusterStore = stor.GetClusterStore(clusterName, now)
 	assert.Equal(t, 0, len(clusterStore.Alerts))
-	err = DiagnoseCluster(client, cfg, clusterStore, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
 	require.Nil(t, err)
 
 	alerts = clusterStore.Alerts
@@ -250,7 +270,7 @@ func Test_Diagnose_EventsDeduplicationOnRpcError(t *testing.T) {
 	require.Nil(t, err)
 	clusterStore := stor.GetClusterStore(clusterName, now)
 	assert.Equal(t, 0, len(clusterStore.Alerts))
-	err = DiagnoseCluster(client, cfg, clusterStore, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
 	require.Nil(t, err)
 
 	alerts := clusterStore.Alerts
@@ -279,9 +299,502 @@ func Test_Diagnose_EventsDeduplicationOnRpcError(t *testing.T) {
 	now = now.Add(time.Minute * time.Duration(17))
 	clusterStore = stor.GetClusterStore(clusterName, now)
 	assert.Equal(t, 0, len(clusterStore.Alerts))
-	err = DiagnoseCluster(client, cfg, clusterStore, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
 	require.Nil(t, err)
 
 	alerts = clusterStore.Alerts
 	assert.Equal(t, 0, len(alerts))
 }
+
+func Test_Diagnose_CustomRules(t *testing.T) {
+	cfg, client := setUp(t, "integration-test-outputs")
+
+	rulesFilePath := path.Join(t.TempDir(), "rules.yaml")
+	// language=yaml
+	err := ioutil.WriteFile(rulesFilePath, []byte(`
+rules:
+  - name: healthy-pod-spotted
+    match:
+      kind: Pod
+      namespaces: [default]
+    expr: object.metadata.name startsWith "test-1-healthy-"
+    message: "Pod {{ .object.metadata.name }} is suspiciously healthy"
+    severity: info
+`), 0644)
+	require.Nil(t, err)
+	cfg.Rules, err = rules.Load(rulesFilePath)
+	require.Nil(t, err)
+
+	now := asTime("2021-10-17T14:20:00Z")
+	clusterName := "diag-test-rules"
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+
+	alerts := clusterStore.Alerts
+	sort.Sort(alerts)
+	require.Equal(t, 6, len(alerts))
+
+	i := 5
+	assert.Equal(t, "test-1-healthy-78b86cd8d5-6vktk", alerts[i].Name)
+	assert.Equal(t, "Pod", alerts[i].Kind)
+	assert.Equal(t, alert.SeverityInfo, alerts[i].Severity)
+	assert.Equal(t, []string{"Pod test-1-healthy-78b86cd8d5-6vktk is suspiciously healthy"}, alerts[i].Messages)
+
+	err = stor.Flush(now)
+	require.Nil(t, err)
+
+	stor, err = store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	now = now.Add(time.Minute)
+	clusterStore = stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(clusterStore.Alerts))
+}
+
+func Test_Diagnose_NamespaceSelection(t *testing.T) {
+	now := asTime("2021-10-17T14:20:00Z")
+
+	tests := []struct {
+		name              string
+		includeNamespaces []string
+		excludeNamespaces []string
+		namespaceSelector string
+		expectedAlerts    int
+	}{
+		{name: "no selection", expectedAlerts: 5},
+		{name: "include glob", includeNamespaces: []string{"kube-*"}, expectedAlerts: 0},
+		{name: "include regex", includeNamespaces: []string{"/^def.*$/"}, expectedAlerts: 5},
+		{name: "exclude glob", excludeNamespaces: []string{"de?ault"}, expectedAlerts: 0},
+		{name: "label selector", namespaceSelector: "kubernetes.io/metadata.name=default", expectedAlerts: 5},
+		{name: "label selector not matching", namespaceSelector: "kubernetes.io/metadata.name!=default", expectedAlerts: 0},
+		{name: "label selector and include", includeNamespaces: []string{"kube-system"}, namespaceSelector: "kubernetes.io/metadata.name", expectedAlerts: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, client := setUp(t, "integration-test-outputs")
+			var err error
+			cfg.IncludeNamespaces, err = internal.CompileNamePatterns(test.includeNamespaces)
+			require.Nil(t, err)
+			cfg.ExcludeNamespaces, err = internal.CompileNamePatterns(test.excludeNamespaces)
+			require.Nil(t, err)
+			cfg.NamespaceSelector, err = labels.Parse(test.namespaceSelector)
+			require.Nil(t, err)
+
+			stor, err := store.LoadOrCreate(cfg)
+			require.Nil(t, err)
+			clusterStore := stor.GetClusterStore("diag-test-ns-selection", now)
+			err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+			require.Nil(t, err)
+			assert.Equal(t, test.expectedAlerts, len(clusterStore.Alerts))
+		})
+	}
+}
+
+func Test_Diagnose_WorkloadSelector(t *testing.T) {
+	cfg, client := setUp(t, "integration-test-outputs")
+	cfg.WorkloadSelector = "app in (test-1-healthy,test-4-crashlooping)"
+
+	now := asTime("2021-10-17T14:20:00Z")
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore("diag-test-workload-selector", now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+
+	alerts := clusterStore.Alerts
+	require.Equal(t, 1, len(alerts))
+	assert.Equal(t, "test-4-crashlooping-dbdd84589-8m7kj", alerts[0].Name)
+	assert.Equal(t, 1, len(alerts[0].Events))
+}
+
+func Test_Diagnose_ConcurrentNamespacesAreDeterministic(t *testing.T) {
+	now := asTime("2021-10-17T14:20:00Z")
+
+	diagnose := func(concurrency int) alert.EntityAlerts {
+		cfg, client := setUp(t, "integration-test-outputs")
+		cfg.NamespaceConcurrency = concurrency
+		stor, err := store.LoadOrCreate(cfg)
+		require.Nil(t, err)
+		clusterStore := stor.GetClusterStore("diag-test-concurrency", now)
+		err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+		require.Nil(t, err)
+		sort.Sort(clusterStore.Alerts)
+		return clusterStore.Alerts
+	}
+
+	expected := diagnose(1)
+	assert.Equal(t, 5, len(expected))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, diagnose(16))
+	}
+}
+
+func Test_Diagnose_NamespaceScopedPermissions(t *testing.T) {
+	now := asTime("2021-10-17T14:20:00Z")
+
+	cfg, client := setUp(t, "integration-test-outputs")
+	client.(interface{ ForbidClusterScope() }).ForbidClusterScope()
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	err = DiagnoseCluster(context.Background(), client, cfg, stor.GetClusterStore("diag-test-ns-scoped", now), now)
+	require.NotNil(t, err)
+
+	cfg.IncludeNamespaces, err = internal.CompileNamePatterns([]string{"default", "kube-*"})
+	require.Nil(t, err)
+	cfg.NamespaceSelector, err = labels.Parse("team=payments")
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore("diag-test-ns-scoped", now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	assert.Equal(t, 5, len(clusterStore.Alerts))
+}
+
+func Test_Diagnose_IncompleteScan(t *testing.T) {
+	cfg, client := setUp(t, "integration-test-outputs")
+	now := asTime("2021-10-17T14:20:00Z")
+	clusterName := "diag-test-incomplete"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(ctx, client, cfg, clusterStore, now)
+	require.Nil(t, err)
+
+	alerts := clusterStore.Alerts
+	require.Equal(t, 1, len(alerts))
+	assert.Equal(t, "Cluster", alerts[0].Kind)
+	assert.Equal(t, clusterName, alerts[0].Name)
+	assert.Equal(t, []string{"Scan incomplete: context canceled, alerts of objects that were not scanned are missing"}, alerts[0].Messages)
+	assert.Equal(t, alert.SeverityWarning, alerts[0].Severity)
+}
+
+func Test_Diagnose_EventsSinceLastRun(t *testing.T) {
+	cfg, client := setUp(t, "integration-test-outputs")
+	now := asTime("2021-10-17T14:20:00Z")
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore("diag-test-events-since", now)
+	clusterStore.LastRunAt = now.Add(-time.Minute)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	alerts := clusterStore.Alerts
+	sort.Sort(alerts)
+	require.Equal(t, 5, len(alerts))
+	assert.Equal(t, "test-2-broken-image-7cbf974df9-4jv8f", alerts[3].Name)
+	assert.Equal(t, 2, len(alerts[3].Events), "events seen within the overlap before the last run are kept")
+
+	stor, err = store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore = stor.GetClusterStore("diag-test-events-since", now)
+	clusterStore.LastRunAt = now.Add(time.Minute * 10)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	alerts = clusterStore.Alerts
+	require.Equal(t, 5, len(alerts))
+	for _, entityAlert := range alerts {
+		assert.Equal(t, 0, len(entityAlert.Events))
+	}
+}
+
+// failingPodsClient mimics a cluster whose pods cannot be listed
+type failingPodsClient struct {
+	kubeclient.KubernetesClient
+}
+
+func (client *failingPodsClient) GetPods(ctx context.Context, namespace string) ([]v1.Pod, error) {
+	return nil, fmt.Errorf("failed to list pods in namespace %v: connection refused", namespace)
+}
+
+func Test_Diagnose_EventsSinceLastRunPerCluster(t *testing.T) {
+	cfg, client := setUp(t, "integration-test-outputs")
+	now := asTime("2021-10-17T14:20:00Z")
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	healthyStore := stor.GetClusterStore("diag-test-events-since-healthy", now)
+	err = DiagnoseCluster(context.Background(), client, cfg, healthyStore, now)
+	require.Nil(t, err)
+	failingStore := stor.GetClusterStore("diag-test-events-since-failing", now)
+	err = DiagnoseCluster(context.Background(), &failingPodsClient{client}, cfg, failingStore, now)
+	require.NotNil(t, err)
+	assert.True(t, now.Equal(healthyStore.LastRunAt))
+	assert.True(t, failingStore.LastRunAt.IsZero(), "a failed diagnosis does not move the events window")
+	err = stor.Flush(now)
+	require.Nil(t, err)
+
+	// the failed cluster is diagnosed again, after the healthy one moved its own events window
+	stor, err = store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	healthyStore = stor.GetClusterStore("diag-test-events-since-healthy", now)
+	assert.True(t, now.Equal(healthyStore.LastRunAt))
+	failingStore = stor.GetClusterStore("diag-test-events-since-failing", now)
+	assert.True(t, failingStore.LastRunAt.IsZero())
+	err = DiagnoseCluster(context.Background(), client, cfg, failingStore, now)
+	require.Nil(t, err)
+	alerts := failingStore.Alerts
+	sort.Sort(alerts)
+	require.Equal(t, 5, len(alerts))
+	assert.Equal(t, "test-2-broken-image-7cbf974df9-4jv8f", alerts[3].Name)
+	assert.Equal(t, 2, len(alerts[3].Events), "events of the failed run window are listed")
+	assert.True(t, now.Equal(failingStore.LastRunAt))
+}
+
+func Test_Diagnose_RecordAndReplay(t *testing.T) {
+	cfg, client := setUp(t, "integration-test-outputs")
+	now := asTime("2021-10-17T14:20:00Z")
+	clusterName := "diag-test-record"
+	recordDirPath := t.TempDir()
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore(clusterName, now)
+	clusterStore.LastRunAt = now.Add(-time.Minute)
+	snapshotFilePath := path.Join(recordDirPath, "store.json")
+	require.Nil(t, stor.Snapshot(snapshotFilePath))
+
+	recorder := kubeclient.NewRecordingClient(client, nil)
+	err = DiagnoseCluster(context.Background(), recorder, cfg, clusterStore, now)
+	require.Nil(t, err)
+	recordedAlerts := clusterStore.Alerts
+	sort.Sort(recordedAlerts)
+	require.NotEmpty(t, recordedAlerts)
+	require.Nil(t, recorder.Save(path.Join(recordDirPath, clusterName), clusterName, now))
+
+	replayClient, err := kubeclient.CreateReplayClient(path.Join(recordDirPath, clusterName))
+	require.Nil(t, err)
+	assert.Equal(t, clusterName, replayClient.Recording.Cluster)
+	assert.True(t, now.Equal(replayClient.Recording.Now))
+
+	cfg.StoreFilePath = snapshotFilePath
+	stor, err = store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore = stor.GetClusterStore(clusterName, replayClient.Recording.Now)
+	err = DiagnoseCluster(context.Background(), replayClient, cfg, clusterStore, replayClient.Recording.Now)
+	require.Nil(t, err)
+	replayedAlerts := clusterStore.Alerts
+	sort.Sort(replayedAlerts)
+	assert.Equal(t, recordedAlerts, replayedAlerts)
+}
+
+func Test_Diagnose_MissingAPIsDisableChecks(t *testing.T) {
+	cfg, _ := setUp(t, "integration-test-outputs")
+	client, err := kubeclient.CreateMockClient(
+		nil,
+		"",
+		path.Join(apiResponsesDirectoryPath, "integration-test-outputs", "ns.json"),
+		"",
+		path.Join(apiResponsesDirectoryPath, "get-rs", "quota_exceeded.json"),
+		"",
+	)
+	require.Nil(t, err)
+	now := asTime("2021-10-17T14:20:00Z")
+
+	countReplicaSetAlerts := func(alerts alert.EntityAlerts) (count int) {
+		for _, entityAlert := range alerts {
+			if entityAlert.Kind == "ReplicaSet" {
+				count++
+			}
+		}
+		return
+	}
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore("diag-test-capabilities", now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	require.NotNil(t, clusterStore.Capabilities)
+	assert.True(t, clusterStore.Capabilities.Serves("apps/v1"))
+	require.Greater(t, countReplicaSetAlerts(clusterStore.Alerts), 0)
+
+	capabilities := &alert.ClusterCapabilities{GroupVersions: []string{"v1"}}
+	client.SetCapabilities(capabilities)
+
+	stor, err = store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore = stor.GetClusterStore("diag-test-capabilities", now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	assert.Equal(t, capabilities, clusterStore.Capabilities)
+	assert.Equal(t, 0, countReplicaSetAlerts(clusterStore.Alerts))
+}
+
+func Test_Diagnose_ClusterAliasAndMetadata(t *testing.T) {
+	cfg, client := setUp(t, "integration-test-outputs")
+	cfg.ClustersInfo = &config.ClustersInfo{
+		Clusters: []*config.ClusterInfo{{
+			Context:  "arn:aws:eks:us-east-1:123:cluster/prod-a",
+			Alias:    "prod-a",
+			Metadata: map[string]string{"environment": "prod", "team": "payments"},
+		}},
+	}
+
+	now := asTime("2021-10-17T14:20:00Z")
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore("arn:aws:eks:us-east-1:123:cluster/prod-a", now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+
+	require.NotEmpty(t, clusterStore.Alerts)
+	for _, entityAlert := range clusterStore.Alerts {
+		assert.Equal(t, "arn:aws:eks:us-east-1:123:cluster/prod-a", entityAlert.ClusterName)
+		assert.Equal(t, "prod-a", entityAlert.ClusterDisplayName())
+		assert.Equal(t, map[string]string{"environment": "prod", "team": "payments"}, entityAlert.ClusterMetadata)
+	}
+
+	otherStore := stor.GetClusterStore("staging", now)
+	err = DiagnoseCluster(context.Background(), client, cfg, otherStore, now)
+	require.Nil(t, err)
+	require.NotEmpty(t, otherStore.Alerts)
+	for _, entityAlert := range otherStore.Alerts {
+		assert.Equal(t, "staging", entityAlert.ClusterDisplayName())
+		assert.Nil(t, entityAlert.ClusterMetadata)
+	}
+}
+
+func Test_Diagnose_ResolvedAlerts(t *testing.T) {
+	cfg, client := setUp(t, "integration-test-outputs")
+
+	now := asTime("2021-10-17T14:20:00Z")
+	clusterName := "diag-test-resolved"
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	require.Equal(t, 5, len(clusterStore.Alerts))
+	firingFingerprints := map[string]string{}
+	for _, entityAlert := range clusterStore.Alerts {
+		assert.Equal(t, alert.StatusFiring, entityAlert.Status)
+		firingFingerprints[entityAlert.Name] = entityAlert.Fingerprint
+	}
+	err = stor.Flush(now)
+	require.Nil(t, err)
+
+	// still unhealthy, deduplicated and not resolved
+	now = now.Add(time.Minute)
+	stor, err = store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore = stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(clusterStore.Alerts))
+	err = stor.Flush(now)
+	require.Nil(t, err)
+
+	// the pods are gone
+	clientWithoutPods, err := kubeclient.CreateMockClient(
+		nil,
+		path.Join(apiResponsesDirectoryPath, "integration-test-outputs", "nodes.json"),
+		path.Join(apiResponsesDirectoryPath, "integration-test-outputs", "ns.json"),
+		"",
+		"",
+		"",
+	)
+	require.Nil(t, err)
+	now = now.Add(time.Minute * time.Duration(10))
+	stor, err = store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore = stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), clientWithoutPods, cfg, clusterStore, now)
+	require.Nil(t, err)
+	require.Equal(t, 5, len(clusterStore.Alerts))
+	for _, entityAlert := range clusterStore.Alerts {
+		assert.Equal(t, alert.StatusResolved, entityAlert.Status)
+		assert.Equal(t, firingFingerprints[entityAlert.Name], entityAlert.Fingerprint)
+		require.NotNil(t, entityAlert.ResolvedAt)
+		assert.True(t, now.Equal(*entityAlert.ResolvedAt))
+		require.Equal(t, 1, len(entityAlert.Messages))
+		assert.Contains(t, entityAlert.Messages[0], "is gone, after being un-healthy for")
+		assert.Contains(t, entityAlert.String(), "[resolved]")
+	}
+	assert.Empty(t, clusterStore.FiringAlertsPerEntity)
+	err = stor.Flush(now)
+	require.Nil(t, err)
+
+	// resolved alerts are not repeated, and a recurring problem is alerted on again
+	now = now.Add(time.Minute)
+	stor, err = store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore = stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), clientWithoutPods, cfg, clusterStore, now)
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(clusterStore.Alerts))
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	require.NotEmpty(t, clusterStore.Alerts)
+	for _, entityAlert := range clusterStore.Alerts {
+		assert.Equal(t, alert.StatusFiring, entityAlert.Status)
+		assert.NotEmpty(t, entityAlert.Messages)
+	}
+}
+
+func Test_Diagnose_ResolvedAlertsOutOfScope(t *testing.T) {
+	cfg, _ := setUp(t, "integration-test-outputs")
+	client, err := kubeclient.CreateMockClient(
+		nil,
+		path.Join(apiResponsesDirectoryPath, "integration-test-outputs", "nodes.json"),
+		path.Join(apiResponsesDirectoryPath, "integration-test-outputs", "ns.json"),
+		path.Join(apiResponsesDirectoryPath, "integration-test-outputs", "pods.json"),
+		path.Join(apiResponsesDirectoryPath, "integration-test-outputs", "rs.json"),
+		path.Join(apiResponsesDirectoryPath, "integration-test-outputs", "events.json"),
+	)
+	require.Nil(t, err)
+
+	now := asTime("2021-10-17T14:20:00Z")
+	clusterName := "diag-test-resolved-out-of-scope"
+	nodeName := store.EntityName{Kind: "Node", Name: "diag-test-node"}
+
+	stor, err := store.LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	require.Equal(t, 5, len(clusterStore.Alerts))
+	clusterStore.Fire(nodeName, &alert.EntityAlert{Fingerprint: "node", Severity: alert.SeverityCritical, Timestamp: now})
+	clusterStore.Fire(nodeName, &alert.EntityAlert{Fingerprint: "node-pressure", Severity: alert.SeverityCritical, Timestamp: now})
+	require.Equal(t, 6, len(clusterStore.FiringAlertsPerEntity))
+
+	// nodes are not permitted to be listed, and pods of the excluded namespace are not listed
+	client.ForbidClusterScope()
+	cfg.IncludeNamespaces, err = internal.CompileNamePatterns([]string{"default", "kube-system"})
+	require.Nil(t, err)
+	cfg.ExcludeNamespaces, err = internal.CompileNamePatterns([]string{"default"})
+	require.Nil(t, err)
+	now = now.Add(time.Minute)
+	clusterStore = stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), client, cfg, clusterStore, now)
+	require.Nil(t, err)
+	for _, entityAlert := range clusterStore.Alerts {
+		assert.NotEqual(t, alert.StatusResolved, entityAlert.Status, entityAlert.String())
+	}
+	assert.Equal(t, 6, len(clusterStore.FiringAlertsPerEntity), "entities which were not scanned are kept firing")
+	assert.NotNil(t, clusterStore.FiringAlertsPerEntity[nodeName.String()])
+
+	// once scanned, the node which is not listed is gone
+	_, unrestrictedClient := setUp(t, "integration-test-outputs")
+	cfg.IncludeNamespaces = nil
+	cfg.ExcludeNamespaces = nil
+	clusterStore = stor.GetClusterStore(clusterName, now)
+	err = DiagnoseCluster(context.Background(), unrestrictedClient, cfg, clusterStore, now)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(clusterStore.Alerts), "each fingerprint the node fired with is resolved")
+	for i, fingerprint := range []string{"node", "node-pressure"} {
+		assert.Equal(t, alert.StatusResolved, clusterStore.Alerts[i].Status)
+		assert.Equal(t, nodeName.Name, clusterStore.Alerts[i].Name)
+		assert.Equal(t, fingerprint, clusterStore.Alerts[i].Fingerprint)
+	}
+	assert.Equal(t, 5, len(clusterStore.FiringAlertsPerEntity))
+}
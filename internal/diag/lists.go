@@ -0,0 +1,124 @@
+package diag
+
+import (
+	"fmt"
+	"github.com/reallyliri/kubescout/internal/kubeclient"
+	log "github.com/sirupsen/logrus"
+	v12 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// objects listed once across all namespaces, grouped by namespace
+type clusterWideLists struct {
+	eventsByNamespace      map[string][]v1.Event
+	replicaSetsByNamespace map[string][]v12.ReplicaSet
+	podsByNamespace        map[string][]v1.Pod
+}
+
+// listNamespaces lists the cluster namespaces, or falls back to the included namespaces when not permitted to list them
+func (context *diagContext) listNamespaces() (namespaces []v1.Namespace, listed bool, err error) {
+	namespaces, err = context.client.GetNamespaces(context.ctx)
+	if err == nil {
+		return namespaces, true, nil
+	}
+	if !kubeclient.IsForbidden(err) {
+		return nil, false, err
+	}
+
+	var names []string
+	if context.includedNamespaces != nil {
+		names = context.includedNamespaces.ExactNames()
+	}
+	if len(names) == 0 {
+		return nil, false, fmt.Errorf("not permitted to list namespaces, set the namespaces to scan explicitly with include-ns: %v", err)
+	}
+	log.Warnf("Not permitted to list namespaces, scanning only the included namespaces %v", names)
+	if context.namespaceSelector != nil && !context.namespaceSelector.Empty() {
+		log.Warnf("Namespace labels are unknown, ignoring the namespace selector '%v'", context.namespaceSelector)
+		context.namespaceSelector = nil
+	}
+	for _, name := range names {
+		namespaces = append(namespaces, v1.Namespace{
+			ObjectMeta: metaV1.ObjectMeta{Name: name},
+		})
+	}
+	return namespaces, false, nil
+}
+
+// listClusterWide lists events, replica sets and pods of all namespaces with a single call per kind,
+// it returns nil if any of the calls fails, so the objects are listed per namespace instead
+func (context *diagContext) listClusterWide() *clusterWideLists {
+	client := context.client
+	lists := &clusterWideLists{
+		eventsByNamespace:      map[string][]v1.Event{},
+		replicaSetsByNamespace: map[string][]v12.ReplicaSet{},
+		podsByNamespace:        map[string][]v1.Pod{},
+	}
+
+	events, err := client.GetEvents(context.ctx, "", context.eventsSince)
+	if err != nil {
+		logClusterWideListError(err)
+		return nil
+	}
+	for _, event := range events {
+		lists.eventsByNamespace[event.Namespace] = append(lists.eventsByNamespace[event.Namespace], event)
+	}
+
+	replicaSets, err := context.listReplicaSets("")
+	if err != nil {
+		logClusterWideListError(err)
+		return nil
+	}
+	for _, replicaSet := range replicaSets {
+		lists.replicaSetsByNamespace[replicaSet.Namespace] = append(lists.replicaSetsByNamespace[replicaSet.Namespace], replicaSet)
+	}
+
+	pods, err := client.GetPods(context.ctx, "")
+	if err != nil {
+		logClusterWideListError(err)
+		return nil
+	}
+	for _, pod := range pods {
+		lists.podsByNamespace[pod.Namespace] = append(lists.podsByNamespace[pod.Namespace], pod)
+	}
+
+	log.Debugf("Listed %v events, %v replica sets and %v pods across all namespaces", len(events), len(replicaSets), len(pods))
+	return lists
+}
+
+func logClusterWideListError(err error) {
+	if kubeclient.IsForbidden(err) {
+		log.Debugf("Not permitted to list across all namespaces, listing per namespace: %v", err)
+	} else {
+		log.Warnf("Failed to list across all namespaces, listing per namespace: %v", err)
+	}
+}
+
+func (context *diagContext) getEvents(namespace string, lists *clusterWideLists) ([]v1.Event, error) {
+	if lists != nil {
+		return lists.eventsByNamespace[namespace], nil
+	}
+	return context.client.GetEvents(context.ctx, namespace, context.eventsSince)
+}
+
+func (context *diagContext) getReplicaSets(namespace string, lists *clusterWideLists) ([]v12.ReplicaSet, error) {
+	if lists != nil {
+		return lists.replicaSetsByNamespace[namespace], nil
+	}
+	return context.listReplicaSets(namespace)
+}
+
+func (context *diagContext) listReplicaSets(namespace string) ([]v12.ReplicaSet, error) {
+	if !context.capabilities.Serves(replicaSetsGroupVersion) {
+		return nil, nil
+	}
+	return context.client.GetReplicaSets(context.ctx, namespace)
+}
+
+func (context *diagContext) getPods(namespace string, lists *clusterWideLists) ([]v1.Pod, error) {
+	if lists != nil {
+		return lists.podsByNamespace[namespace], nil
+	}
+	return context.client.GetPods(context.ctx, namespace)
+}
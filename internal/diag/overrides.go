@@ -0,0 +1,150 @@
+package diag
+
+import (
+	"github.com/reallyliri/kubescout/alert"
+	log "github.com/sirupsen/logrus"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+	"time"
+)
+
+const annotationsPrefix = "kubescout.io/"
+
+const (
+	ignoreAnnotation            = annotationsPrefix + "ignore"
+	restartGraceCountAnnotation = annotationsPrefix + "restart-grace-count"
+	startingGraceSecAnnotation  = annotationsPrefix + "starting-grace-sec"
+	dedupMinutesAnnotation      = annotationsPrefix + "dedup-minutes"
+	severityAnnotation          = annotationsPrefix + "severity"
+)
+
+// overrides of the global config, set with annotations on pods, their owners or namespaces
+type overrides struct {
+	ignore            *bool
+	restartGraceCount *int32
+	startingGraceSec  *float64
+	dedupDuration     *time.Duration
+	severity          alert.Severity
+}
+
+func parseOverrides(kind string, name string, annotations map[string]string) (parsed overrides) {
+	if value, found := annotations[ignoreAnnotation]; found {
+		ignore, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Warnf("invalid value '%v' for annotation %v on %v %v: %v", value, ignoreAnnotation, kind, name, err)
+		} else {
+			parsed.ignore = &ignore
+		}
+	}
+	if value, found := annotations[restartGraceCountAnnotation]; found {
+		count, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			log.Warnf("invalid value '%v' for annotation %v on %v %v: %v", value, restartGraceCountAnnotation, kind, name, err)
+		} else {
+			restartGraceCount := int32(count)
+			parsed.restartGraceCount = &restartGraceCount
+		}
+	}
+	if value, found := annotations[startingGraceSecAnnotation]; found {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Warnf("invalid value '%v' for annotation %v on %v %v: %v", value, startingGraceSecAnnotation, kind, name, err)
+		} else {
+			parsed.startingGraceSec = &seconds
+		}
+	}
+	if value, found := annotations[dedupMinutesAnnotation]; found {
+		minutes, err := strconv.Atoi(value)
+		if err != nil {
+			log.Warnf("invalid value '%v' for annotation %v on %v %v: %v", value, dedupMinutesAnnotation, kind, name, err)
+		} else {
+			dedupDuration := time.Minute * time.Duration(minutes)
+			parsed.dedupDuration = &dedupDuration
+		}
+	}
+	if value, found := annotations[severityAnnotation]; found {
+		severity, err := alert.ParseSeverity(value)
+		if err != nil {
+			log.Warnf("invalid value '%v' for annotation %v on %v %v: %v", value, severityAnnotation, kind, name, err)
+		} else {
+			parsed.severity = severity
+		}
+	}
+	return
+}
+
+// merge returns the overrides with values set on higher taking precedence
+func (lower overrides) merge(higher overrides) overrides {
+	merged := lower
+	if higher.ignore != nil {
+		merged.ignore = higher.ignore
+	}
+	if higher.restartGraceCount != nil {
+		merged.restartGraceCount = higher.restartGraceCount
+	}
+	if higher.startingGraceSec != nil {
+		merged.startingGraceSec = higher.startingGraceSec
+	}
+	if higher.dedupDuration != nil {
+		merged.dedupDuration = higher.dedupDuration
+	}
+	if higher.severity != "" {
+		merged.severity = higher.severity
+	}
+	return merged
+}
+
+func (context *diagContext) ownerOverrides(namespace string, ownerReferences []metaV1.OwnerReference) (owner overrides) {
+	for _, reference := range ownerReferences {
+		switch reference.Kind {
+		case "ReplicaSet":
+			owner = owner.merge(context.replicaSetOverrides[namespace+"/"+reference.Name])
+		case "StatefulSet", "DaemonSet", "Job":
+			owner = owner.merge(context.workloadOverrides(namespace, reference.Kind, reference.Name))
+		}
+	}
+	return
+}
+
+// workloadOverrides of a pod controller which is not listed, its annotations are fetched once per diagnosis
+func (context *diagContext) workloadOverrides(namespace string, kind string, name string) overrides {
+	key := kind + "/" + namespace + "/" + name
+	if cached, found := context.workloadsOverrides[key]; found {
+		return cached
+	}
+	var parsed overrides
+	if context.client != nil {
+		annotations, err := context.client.GetWorkloadAnnotations(context.ctx, namespace, kind, name)
+		if err != nil {
+			log.Warnf("Failed to get annotations of %v %v/%v, overrides of its pods are not applied: %v", kind, namespace, name, err)
+		}
+		parsed = parseOverrides(kind, name, annotations)
+	}
+	context.workloadsOverrides[key] = parsed
+	return parsed
+}
+
+func (context *diagContext) isIgnored(state *entityState) bool {
+	return state.overrides.ignore != nil && *state.overrides.ignore
+}
+
+func (context *diagContext) restartGraceCount(state *entityState) int32 {
+	if state.overrides.restartGraceCount != nil {
+		return *state.overrides.restartGraceCount
+	}
+	return context.config.PodRestartGraceCount
+}
+
+func (context *diagContext) startingGraceSec(state *entityState) float64 {
+	if state.overrides.startingGraceSec != nil {
+		return *state.overrides.startingGraceSec
+	}
+	return context.config.PodStartingGracePeriodSeconds
+}
+
+func (context *diagContext) dedupDuration(state *entityState) time.Duration {
+	if state.overrides.dedupDuration != nil {
+		return *state.overrides.dedupDuration
+	}
+	return context.config.MessagesDeduplicationDuration
+}
@@ -0,0 +1,113 @@
+package diag
+
+import (
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/internal/kubeclient"
+	"github.com/stretchr/testify/require"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestParseOverrides(t *testing.T) {
+	parsed := parseOverrides("Pod", "pod", map[string]string{
+		"kubescout.io/ignore":              "true",
+		"kubescout.io/restart-grace-count": "10",
+		"kubescout.io/starting-grace-sec":  "120.5",
+		"kubescout.io/dedup-minutes":       "30",
+		"kubescout.io/severity":            "Critical",
+	})
+	require.True(t, *parsed.ignore)
+	require.Equal(t, int32(10), *parsed.restartGraceCount)
+	require.Equal(t, 120.5, *parsed.startingGraceSec)
+	require.Equal(t, 30*time.Minute, *parsed.dedupDuration)
+	require.Equal(t, alert.SeverityCritical, parsed.severity)
+
+	parsed = parseOverrides("Pod", "pod", map[string]string{
+		"kubescout.io/ignore":              "maybe",
+		"kubescout.io/restart-grace-count": "many",
+		"kubescout.io/severity":            "urgent",
+		"other.io/severity":                "info",
+	})
+	require.Nil(t, parsed.ignore)
+	require.Nil(t, parsed.restartGraceCount)
+	require.Equal(t, alert.Severity(""), parsed.severity)
+}
+
+func TestOverridesPrecedence(t *testing.T) {
+	namespace := parseOverrides("Namespace", "ns", map[string]string{
+		"kubescout.io/restart-grace-count": "1",
+		"kubescout.io/dedup-minutes":       "5",
+		"kubescout.io/severity":            "info",
+	})
+	owner := parseOverrides("ReplicaSet", "rs", map[string]string{
+		"kubescout.io/restart-grace-count": "2",
+		"kubescout.io/severity":            "warning",
+	})
+	pod := parseOverrides("Pod", "pod", map[string]string{
+		"kubescout.io/restart-grace-count": "3",
+	})
+
+	merged := namespace.merge(owner).merge(pod)
+	require.Equal(t, int32(3), *merged.restartGraceCount)
+	require.Equal(t, alert.SeverityWarning, merged.severity)
+	require.Equal(t, 5*time.Minute, *merged.dedupDuration)
+	require.Nil(t, merged.ignore)
+}
+
+func TestPodState_RestartGraceCountAnnotation(t *testing.T) {
+	pods, err := kubeclient.GetPods(t, "excessive_restart.json")
+	require.Nil(t, err)
+	require.NotEmpty(t, pods)
+
+	now := asTime("2021-07-14T13:40:00Z")
+	restartingPod := pods[11]
+
+	state, err := testContext(now).podState(&restartingPod)
+	require.Nil(t, err)
+	require.False(t, state.isHealthy())
+
+	restartingPod.Annotations = map[string]string{"kubescout.io/restart-grace-count": "10"}
+	state, err = testContext(now).podState(&restartingPod)
+	require.Nil(t, err)
+	require.True(t, state.isHealthy())
+
+	restartingPod.Annotations = nil
+	context := testContext(now)
+	context.replicaSetOverrides[restartingPod.Namespace+"/owner"] = parseOverrides("ReplicaSet", "owner", map[string]string{
+		"kubescout.io/restart-grace-count": "10",
+	})
+	restartingPod.OwnerReferences = []metaV1.OwnerReference{{Kind: "ReplicaSet", Name: "owner"}}
+	state, err = context.podState(&restartingPod)
+	require.Nil(t, err)
+	require.True(t, state.isHealthy())
+}
+
+func TestPodState_StatefulSetOverrides(t *testing.T) {
+	pods, err := kubeclient.GetPods(t, "excessive_restart.json")
+	require.Nil(t, err)
+	now := asTime("2021-07-14T13:40:00Z")
+	restartingPod := pods[11]
+	restartingPod.Annotations = nil
+	restartingPod.OwnerReferences = []metaV1.OwnerReference{{Kind: "StatefulSet", Name: "owner"}}
+
+	client, err := kubeclient.CreateMockClient(nil, "", "", "", "", "")
+	require.Nil(t, err)
+	state, err := testContextWithClient(now, client).podState(&restartingPod)
+	require.Nil(t, err)
+	require.False(t, state.isHealthy())
+
+	client.SetWorkloadAnnotations("StatefulSet", restartingPod.Namespace, "owner", map[string]string{
+		"kubescout.io/restart-grace-count": "10",
+		"kubescout.io/severity":            "critical",
+	})
+	state, err = testContextWithClient(now, client).podState(&restartingPod)
+	require.Nil(t, err)
+	require.True(t, state.isHealthy())
+	require.Equal(t, alert.SeverityCritical, state.overrides.severity)
+
+	restartingPod.Annotations = map[string]string{"kubescout.io/restart-grace-count": "1"}
+	state, err = testContextWithClient(now, client).podState(&restartingPod)
+	require.Nil(t, err)
+	require.False(t, state.isHealthy(), "pod annotations take precedence over its stateful set")
+}
@@ -208,7 +208,7 @@ func TestPodState_CreateFailed(t *testing.T) {
 		11: true,
 	})
 
-	mockClient, err := kubeclient.CreateMockClient("", "", "", "", "")
+	mockClient, err := kubeclient.CreateMockClient(nil, "", "", "", "", "")
 	require.Nil(t, err)
 
 	failingPod := pods[6]
@@ -260,7 +260,7 @@ func TestPodState_InitContainerCrashlooping(t *testing.T) {
 	}
 	verifyPodsHealthyExcept(t, pods, now, skipIndexes)
 
-	mockClient, err := kubeclient.CreateMockClient("", "", "", "", "")
+	mockClient, err := kubeclient.CreateMockClient(nil, "", "", "", "", "")
 	require.Nil(t, err)
 
 	for _, index := range crashloopingIndexes {
@@ -0,0 +1,17 @@
+package diag
+
+import (
+	"time"
+)
+
+func (context *diagContext) applyRules(state *entityState, labels map[string]string, object interface{}) error {
+	if context.rules == nil || context.rules.Empty() {
+		return nil
+	}
+	results, err := context.rules.Evaluate(state.name.Kind, state.name.Namespace, labels, object)
+	for _, result := range results {
+		state.markSeverity(result.RuleName, result.Severity)
+		state.appendMessage(time.Time{}, result.Message)
+	}
+	return err
+}
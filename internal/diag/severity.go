@@ -0,0 +1,56 @@
+package diag
+
+import (
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/internal/store"
+)
+
+var eventReasonToDefaultSeverity = map[string]alert.Severity{
+	"Unhealthy": alert.SeverityInfo,
+}
+
+// severities of state reasons which are more severe than detected, can be overridden by reason like any other
+var stateReasonToDefaultSeverity = map[string]alert.Severity{
+	"OOMKilled":        alert.SeverityCritical,
+	"CrashLoopBackOff": alert.SeverityCritical,
+}
+
+func stateDefaultSeverity(reason string, severity alert.Severity) alert.Severity {
+	if defaultSeverity, found := stateReasonToDefaultSeverity[reason]; found {
+		return alert.MoreSevere(severity, defaultSeverity)
+	}
+	return severity
+}
+
+func (context *diagContext) overrideSeverity(name store.EntityName, reason string, severity alert.Severity) alert.Severity {
+	if override, found := context.config.SeverityByReason[reason]; found && reason != "" {
+		return override
+	}
+	if override, found := context.config.SeverityByNamespace[name.Namespace]; found && name.Namespace != "" {
+		return override
+	}
+	if override, found := context.config.SeverityByKind[name.Kind]; found {
+		return override
+	}
+	return severity
+}
+
+func eventDefaultSeverity(event *eventState) alert.Severity {
+	if severity, found := eventReasonToDefaultSeverity[event.reason]; found {
+		return severity
+	}
+	return alert.SeverityWarning
+}
+
+func (context *diagContext) alertSeverity(name store.EntityName, severityByReason map[string]alert.Severity, events []*eventState) (severity alert.Severity) {
+	for reason, reasonSeverity := range severityByReason {
+		severity = alert.MoreSevere(severity, context.overrideSeverity(name, reason, reasonSeverity))
+	}
+	for _, event := range events {
+		severity = alert.MoreSevere(severity, context.overrideSeverity(name, event.reason, eventDefaultSeverity(event)))
+	}
+	if severity == "" {
+		severity = context.overrideSeverity(name, "", alert.SeverityWarning)
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package diag
+
+import (
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/internal/kubeclient"
+	"github.com/reallyliri/kubescout/internal/store"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSeverity_NodeNotReady(t *testing.T) {
+	nodes, err := kubeclient.GetNodes(t, "unknown.json")
+	require.Nil(t, err)
+	require.NotEmpty(t, nodes)
+
+	context := testContext(asTime("2021-10-13T15:00:00Z"))
+	state, err := context.nodeState(&nodes[0], true)
+	require.Nil(t, err)
+	require.Equal(t, alert.SeverityCritical, context.alertSeverity(state.name, state.severityByReason, nil))
+}
+
+func TestSeverity_ExcessiveRestarts(t *testing.T) {
+	pods, err := kubeclient.GetPods(t, "excessive_restart.json")
+	require.Nil(t, err)
+	require.NotEmpty(t, pods)
+
+	context := testContext(asTime("2021-07-14T13:40:00Z"))
+	state, err := context.podState(&pods[11])
+	require.Nil(t, err)
+	require.False(t, state.isHealthy())
+	require.Equal(t, alert.SeverityWarning, context.alertSeverity(state.name, state.severityByReason, nil))
+}
+
+func TestSeverity_Events(t *testing.T) {
+	context := testContext(asTime("2021-10-18T09:00:00Z"))
+	name := store.EntityName{Namespace: "default", Kind: "Pod", Name: "pod"}
+	probeFailed := &eventState{name: name, reason: "Unhealthy", message: "Liveness probe failed"}
+	backOff := &eventState{name: name, reason: "BackOff", message: "Back-off restarting failed container"}
+
+	require.Equal(t, alert.SeverityInfo, context.alertSeverity(name, nil, []*eventState{probeFailed}))
+	require.Equal(t, alert.SeverityWarning, context.alertSeverity(name, nil, []*eventState{probeFailed, backOff}))
+}
+
+func TestSeverity_Overrides(t *testing.T) {
+	context := testContext(asTime("2021-10-18T09:00:00Z"))
+	context.config.SeverityByKind = map[string]alert.Severity{"Pod": alert.SeverityCritical}
+	context.config.SeverityByNamespace = map[string]alert.Severity{"dev": alert.SeverityInfo}
+	context.config.SeverityByReason = map[string]alert.Severity{"OOMKilled": alert.SeverityCritical}
+
+	severityByReason := map[string]alert.Severity{"CrashLoopBackOff": alert.SeverityWarning}
+	require.Equal(t, alert.SeverityCritical, context.alertSeverity(store.EntityName{Namespace: "prod", Kind: "Pod"}, severityByReason, nil))
+	require.Equal(t, alert.SeverityInfo, context.alertSeverity(store.EntityName{Namespace: "dev", Kind: "Pod"}, severityByReason, nil))
+	require.Equal(t, alert.SeverityWarning, context.alertSeverity(store.EntityName{Namespace: "prod", Kind: "ReplicaSet"}, severityByReason, nil))
+
+	severityByReason["OOMKilled"] = alert.SeverityWarning
+	require.Equal(t, alert.SeverityCritical, context.alertSeverity(store.EntityName{Namespace: "dev", Kind: "Pod"}, severityByReason, nil))
+}
+
+func TestSeverity_CriticalByDefault(t *testing.T) {
+	context := testContext(asTime("2021-10-18T09:00:00Z"))
+	name := store.EntityName{Namespace: "default", Kind: "Pod", Name: "pod"}
+	state := newState(name, asTime("2021-10-18T08:00:00Z"))
+	state.markSeverity("CrashLoopBackOff", alert.SeverityWarning)
+	state.markSeverity("Restarting", alert.SeverityWarning)
+	require.Equal(t, alert.SeverityCritical, context.alertSeverity(name, state.severityByReason, nil))
+
+	context.config.SeverityByReason = map[string]alert.Severity{"CrashLoopBackOff": alert.SeverityWarning}
+	require.Equal(t, alert.SeverityWarning, context.alertSeverity(name, state.severityByReason, nil), "defaults are overridden by reason")
+
+	state = newState(name, asTime("2021-10-18T08:00:00Z"))
+	state.markSeverity("OOMKilled", alert.SeverityWarning)
+	require.Equal(t, alert.SeverityCritical, context.alertSeverity(name, state.severityByReason, nil))
+}
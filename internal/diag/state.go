@@ -2,6 +2,7 @@ package diag
 
 import (
 	"fmt"
+	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/internal/dedup"
 	"github.com/reallyliri/kubescout/internal/store"
 	log "github.com/sirupsen/logrus"
@@ -40,13 +41,6 @@ func (context *diagContext) addEventState(eName store.EntityName) *eventState {
 	return evState
 }
 
-var ignoreWaitingReasons = map[string]bool{
-	"CrashLoopBackOff":  true,
-	"Completed":         true,
-	"ContainerCreating": true,
-	"PodInitializing":   true,
-}
-
 func (state *entityState) checkContainerStatuses(pod *v1.Pod, context *diagContext) {
 
 	var waitingToCreate []string
@@ -82,16 +76,20 @@ func (state *entityState) checkContainerStatuses(pod *v1.Pod, context *diagConte
 
 	var pending *[]string
 	pendingVerb := ""
+	pendingReason := ""
 	if len(waitingToCreate) > 0 {
 		pendingVerb = "creating"
+		pendingReason = "ContainerCreating"
 		pending = &waitingToCreate
 	} else if len(waitingToInitialize) > 0 {
 		pendingVerb = "initializing"
+		pendingReason = "PodInitializing"
 		pending = &waitingToInitialize
 	}
 
 	if pending != nil {
 		sort.Strings(*pending)
+		state.markSeverity(pendingReason, alert.SeverityWarning)
 		state.appendMessage(
 			pod.CreationTimestamp.Time,
 			"%v still %v [ %v ] (since %v)",
@@ -107,6 +105,7 @@ func (state *entityState) checkContainerStatuses(pod *v1.Pod, context *diagConte
 		for _, condition := range pod.Status.Conditions {
 			if condition.Status != "True" {
 				anyConditionMessage = true
+				state.markSeverity(condition.Reason, alert.SeverityWarning)
 				state.appendMessage(
 					condition.LastTransitionTime.Time,
 					"%v: %v (last transition: %v)",
@@ -118,7 +117,8 @@ func (state *entityState) checkContainerStatuses(pod *v1.Pod, context *diagConte
 		}
 		if !anyConditionMessage {
 			sinceCreation := context.now.Sub(pod.CreationTimestamp.Time).Seconds()
-			if pod.Status.Phase != v1.PodPending || sinceCreation >= context.config.PodStartingGracePeriodSeconds {
+			if pod.Status.Phase != v1.PodPending || sinceCreation >= context.startingGraceSec(state) {
+				state.markSeverity(string(pod.Status.Phase), alert.SeverityWarning)
 				state.appendMessage(
 					pod.CreationTimestamp.Time,
 					"Pod is in %v phase (since %v)",
@@ -169,6 +169,7 @@ func (state *entityState) checkContainerStatus(
 			runProblems = true
 			sinceTerminated := context.now.Sub(stateTerminated.FinishedAt.Time).Seconds()
 			if sinceTerminated >= float64(context.config.PodTerminationGracePeriodSeconds) {
+				state.markSeverity(terminatedReason, alert.SeverityWarning)
 				state.appendMessage(
 					stateTerminated.FinishedAt.Time,
 					"%v%v terminated due to %v (exit code %v)",
@@ -184,12 +185,13 @@ func (state *entityState) checkContainerStatus(
 	if stateWaiting != nil {
 		runProblems = true
 		sinceCreation := context.now.Sub(pod.CreationTimestamp.Time).Seconds()
-		startingGracePassed := sinceCreation >= context.config.PodStartingGracePeriodSeconds
+		startingGracePassed := sinceCreation >= context.startingGraceSec(state)
 		if stateWaiting.Reason == "ContainerCreating" && startingGracePassed {
 			waitingToCreate = true
 		} else if stateWaiting.Reason == "PodInitializing" && startingGracePassed {
 			waitingToInitialize = true
-		} else if !ignoreWaitingReasons[stateWaiting.Reason] {
+		} else if !context.config.IgnoreRules.IgnoreWaitingReason(context.clusterName(), pod.Namespace, stateWaiting.Reason) {
+			state.markSeverity(stateWaiting.Reason, alert.SeverityWarning)
 			state.appendMessage(
 				pod.CreationTimestamp.Time,
 				"%v still waiting due to %v: %v",
@@ -201,7 +203,7 @@ func (state *entityState) checkContainerStatus(
 		}
 	}
 
-	if (!isInitContainer || runProblems) && containerStatus.RestartCount > context.config.PodRestartGraceCount {
+	if (!isInitContainer || runProblems) && containerStatus.RestartCount > context.restartGraceCount(state) {
 		runProblems = true
 		stateTerminated = containerStatus.LastTerminationState.Terminated
 		prefix := title
@@ -218,7 +220,13 @@ func (state *entityState) checkContainerStatus(
 		}
 
 		if !started || isPodExcessiveRestartProblem(context.now, pod.CreationTimestamp.Time, problemTimestamp, pod.Status.StartTime.Time) {
+			if stateWaiting != nil {
+				state.markSeverity(stateWaiting.Reason, alert.SeverityWarning)
+			} else {
+				state.markSeverity("Restarting", alert.SeverityWarning)
+			}
 			if stateTerminated != nil {
+				state.markSeverity(stateTerminated.Reason, alert.SeverityWarning)
 				state.appendMessage(
 					problemTimestamp,
 					"%v restarted %v times, last exit due to %v (exit code %v)",
@@ -239,13 +247,17 @@ func (state *entityState) checkContainerStatus(
 	}
 
 	if shouldCollectLogs && context.client != nil {
-		logs, err := context.client.GetPodLogs(pod.Namespace, pod.Name, containerStatus.Name)
+		var logsSince time.Time
+		if !state.problemTimestamp.IsZero() {
+			logsSince = state.problemTimestamp.Add(-logsLookbackBeforeProblem)
+		}
+		logs, err := context.client.GetPodLogs(context.ctx, pod.Namespace, pod.Name, containerStatus.Name, logsSince)
 		if err != nil {
 			log.Errorf("failed to get logs of %v/%v/%v: %v", pod.Namespace, pod.Name, containerStatus.Name, err)
 		} else {
 			logs = strings.TrimSpace(strings.ReplaceAll(logs, "\r", "\n"))
 			if logs != "" {
-				state.logsCollections[containerStatus.Name] = logs
+				state.addLogs(containerStatus.Name, logs, context.config.LogsExcerptBytes)
 			}
 		}
 	}
@@ -261,6 +273,9 @@ func podRunningTimestamp(pod *v1.Pod) time.Time {
 
 func (context *diagContext) podState(pod *v1.Pod) (state *entityState, err error) {
 	state = context.getOrAddState(pod.Namespace, "Pod", pod.Name, pod.ObjectMeta.CreationTimestamp.Time)
+	state.overrides = context.namespaceOverrides[pod.Namespace].
+		merge(context.ownerOverrides(pod.Namespace, pod.OwnerReferences)).
+		merge(parseOverrides("Pod", pod.Name, pod.Annotations))
 
 	podPhase := pod.Status.Phase
 	if podPhase == v1.PodSucceeded {
@@ -284,6 +299,7 @@ func (context *diagContext) podState(pod *v1.Pod) (state *entityState, err error
 		if statusReason == "Evicted" {
 			statusMessage = dedup.WrapTemporal(formatUnitsSize(statusMessage))
 		}
+		state.markSeverity(statusReason, alert.SeverityWarning)
 		state.appendMessage(
 			podRunningTimestamp(pod),
 			"Pod is in %v phase due to %v: %v",
@@ -296,9 +312,11 @@ func (context *diagContext) podState(pod *v1.Pod) (state *entityState, err error
 			if pod.DeletionGracePeriodSeconds != nil && *pod.DeletionGracePeriodSeconds != 0 {
 				suffix = fmt.Sprintf(" (deletion grace is %v sec)", *pod.DeletionGracePeriodSeconds)
 			}
+			state.markSeverity("Terminating", alert.SeverityWarning)
 			state.appendMessage(deletionTime, "Pod is Terminating since %v%v", dedup.WrapTemporal(formatDuration(deletionTime, context.now)), suffix)
 		}
 	} else if podPhase != v1.PodRunning && podPhase != v1.PodPending {
+		state.markSeverity(string(podPhase), alert.SeverityWarning)
 		state.appendMessage(podRunningTimestamp(pod), "Pod is in %v phase", podPhase)
 	}
 
@@ -325,6 +343,11 @@ func (context *diagContext) nodeState(node *v1.Node, forceCheckResources bool) (
 		if sinceLastTransition < time.Minute {
 			continue
 		}
+		if condition.Type == "Ready" {
+			state.markSeverity("NotReady", alert.SeverityCritical)
+		} else {
+			state.markSeverity(string(condition.Type), alert.SeverityWarning)
+		}
 		state.appendMessage(
 			condition.LastTransitionTime.Time,
 			"%v: %v (last transition: %v)",
@@ -338,6 +361,8 @@ func (context *diagContext) nodeState(node *v1.Node, forceCheckResources bool) (
 		return
 	}
 
+	messagesCount := len(state.messages)
+
 	state.appendMessage(time.Time{}, formatResourceUsage(
 		node.Status.Allocatable.Cpu().MilliValue(),
 		node.Status.Capacity.Cpu().MilliValue(),
@@ -356,11 +381,18 @@ func (context *diagContext) nodeState(node *v1.Node, forceCheckResources bool) (
 		"Ephemeral Storage", context.config.NodeResourceUsageThreshold,
 	))
 
+	if len(state.messages) > messagesCount {
+		state.markSeverity("ExcessiveResourceUsage", alert.SeverityWarning)
+	}
+
 	return
 }
 
 func (context *diagContext) replicaSetState(replicaSet *v12.ReplicaSet) (state *entityState, err error) {
 	state = context.getOrAddState(replicaSet.Namespace, "ReplicaSet", replicaSet.Name, replicaSet.ObjectMeta.CreationTimestamp.Time)
+	state.overrides = context.namespaceOverrides[replicaSet.Namespace].
+		merge(parseOverrides("ReplicaSet", replicaSet.Name, replicaSet.Annotations))
+	context.replicaSetOverrides[replicaSet.Namespace+"/"+replicaSet.Name] = state.overrides
 
 	specDesiredReplicas := replicaSet.Spec.Replicas
 	var desiredReplicas int
@@ -389,6 +421,7 @@ func (context *diagContext) replicaSetState(replicaSet *v12.ReplicaSet) (state *
 	}
 
 	for _, condition := range replicaSet.Status.Conditions {
+		state.markSeverity(condition.Reason, alert.SeverityWarning)
 		state.appendMessage(
 			condition.LastTransitionTime.Time,
 			"%v: %v (last transition: %v)",
@@ -411,15 +444,13 @@ func (context *diagContext) eventState(event *v1.Event) (state *eventState, err
 	}
 
 	state = context.addEventState(eName)
+	state.reason = event.Reason
 
 	if context.isEventHealthy(event) {
 		return state, nil
 	}
 
-	source := event.Source.Component
-	if source == "" {
-		source = event.ReportingController
-	}
+	source := eventSource(event)
 
 	firstTimestamp := event.FirstTimestamp.Time
 	if firstTimestamp.IsZero() {
@@ -480,16 +511,22 @@ func (context *diagContext) eventState(event *v1.Event) (state *eventState, err
 	return state, nil
 }
 
-var eventReasonsToIgnore = map[string]bool{
-	"NodeSysctlChange": true,
-	"ContainerdStart":  true,
-	"DockerStart":      true,
-	"KubeletStart":     true,
+func eventSource(event *v1.Event) string {
+	if event.Source.Component != "" {
+		return event.Source.Component
+	}
+	return event.ReportingController
 }
 
 func (context *diagContext) isEventHealthy(event *v1.Event) bool {
 	return event.Type == "Normal" ||
-		eventReasonsToIgnore[event.Reason] ||
-		(event.Reason == "NodeNotReady" && event.Message == "Node is not ready") ||
-		strings.HasSuffix(event.Message, "please apply your changes to the latest version and try again")
+		context.config.IgnoreRules.IgnoreEvent(
+			context.clusterName(),
+			event.InvolvedObject.Namespace,
+			event.Reason,
+			event.Message,
+			event.InvolvedObject.Kind,
+			event.InvolvedObject.Name,
+			eventSource(event),
+		)
 }
@@ -3,8 +3,10 @@ package diag
 import (
 	"fmt"
 	"github.com/goombaio/orderedmap"
+	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/internal"
 	"github.com/reallyliri/kubescout/internal/dedup"
+	"github.com/reallyliri/kubescout/internal/excerpt"
 	"github.com/reallyliri/kubescout/internal/store"
 	"strings"
 	"time"
@@ -16,11 +18,16 @@ type entityState struct {
 	node             string
 	createdTimestamp time.Time
 	logsCollections  map[string]string
+	logsBytes        int
+	likelyCause      string
 	problemTimestamp time.Time
+	severityByReason map[string]alert.Severity
+	overrides        overrides
 }
 
 type eventState struct {
 	name           store.EntityName
+	reason         string
 	message        string
 	firstTimestamp time.Time
 	lastTimestamp  time.Time
@@ -32,6 +39,7 @@ func newState(entityName store.EntityName, createdTimestamp time.Time) *entitySt
 		messages:         []string{},
 		createdTimestamp: createdTimestamp,
 		logsCollections:  map[string]string{},
+		severityByReason: map[string]alert.Severity{},
 	}
 }
 
@@ -70,6 +78,32 @@ func (state *entityState) appendMessage(timestamp time.Time, format string, a ..
 	setMinTimestamp(&state.problemTimestamp, timestamp)
 }
 
+func (state *entityState) markSeverity(reason string, severity alert.Severity) {
+	severity = stateDefaultSeverity(reason, severity)
+	if current, found := state.severityByReason[reason]; found {
+		severity = alert.MoreSevere(current, severity)
+	}
+	state.severityByReason[reason] = severity
+}
+
+func (state *entityState) addLogs(containerName string, logs string, byteBudget int) {
+	if byteBudget > 0 {
+		byteBudget -= state.logsBytes
+		if byteBudget <= 0 {
+			return
+		}
+	}
+	logsExcerpt := excerpt.Extract(logs, byteBudget)
+	if logsExcerpt.Text == "" {
+		return
+	}
+	state.logsCollections[containerName] = logsExcerpt.Text
+	state.logsBytes += len(logsExcerpt.Text)
+	if state.likelyCause == "" {
+		state.likelyCause = logsExcerpt.LikelyCause
+	}
+}
+
 func (state *eventState) isHealthy() bool {
 	return state.message == ""
 }
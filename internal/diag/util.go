@@ -93,6 +93,14 @@ func formatDuration(olderDate time.Time, newerDate time.Time) string {
 	return humanize.RelTime(olderDate, newerDate, "ago", "")
 }
 
+// formatElapsed is the time between the dates, e.g. '5 minutes'
+func formatElapsed(olderDate time.Time, newerDate time.Time) string {
+	if olderDate.IsZero() || newerDate.Before(olderDate) {
+		return "an unknown time"
+	}
+	return strings.TrimSpace(humanize.RelTime(olderDate, newerDate, "", ""))
+}
+
 func asTime(dateString string) time.Time {
 	parsed, err := time.Parse(time.RFC3339, dateString)
 	if err != nil {
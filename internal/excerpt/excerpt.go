@@ -0,0 +1,302 @@
+package excerpt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const contextLines = 2
+const maxBlockLines = 60
+const maxCauseLength = 250
+const blocksSeparator = "\n...\n"
+
+type signatureKind int
+
+const (
+	goPanic signatureKind = iota
+	pythonTraceback
+	javaException
+	outOfMemory
+	fatalLine
+	errorLine
+)
+
+// rank of each kind, lower is more likely to be the cause of the failure
+var kindToRank = map[signatureKind]int{
+	goPanic:         0,
+	pythonTraceback: 0,
+	javaException:   0,
+	outOfMemory:     1,
+	fatalLine:       2,
+	errorLine:       3,
+}
+
+type signature struct {
+	kind    signatureKind
+	pattern string
+	regex   *regexp.Regexp
+}
+
+// order matters, a line is attributed to the first signature it matches
+var signatures = []*signature{
+	{kind: goPanic, pattern: `(^|\s)(panic: |fatal error: )`},
+	{kind: pythonTraceback, pattern: `Traceback \(most recent call last\):`},
+	{kind: javaException, pattern: `Exception in thread "|(^|\s)([a-z_$][\w$]*\.)+[A-Z][\w$]*(Exception|Error)(:|$)`},
+	{kind: outOfMemory, pattern: `(?i)(out of memory|OutOfMemory|OOMKilled|cannot allocate memory|\bMemoryError\b)`},
+	{kind: fatalLine, pattern: `\b(FATAL|CRITICAL|PANIC)\b|(?i)(\blevel=(fatal|panic)\b|"level"\s*:\s*"(fatal|panic|critical)")`},
+	{kind: errorLine, pattern: `\bERROR\b|^Error: |(?i)(\blevel=error\b|"level"\s*:\s*"error")`},
+}
+
+var stackLineRegex *regexp.Regexp
+
+// lines of logs read with timestamps are prefixed by an RFC3339 timestamp
+var timestampPrefixRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})( |$)`)
+
+func init() {
+	var err error
+	for _, sig := range signatures {
+		sig.regex, err = regexp.Compile(sig.pattern)
+		if err != nil {
+			panic(fmt.Errorf("failed to compile regex: %v", err))
+		}
+	}
+	stackLineRegex, err = regexp.Compile(`^(\s+\S|goroutine \d+ |created by |Caused by: |\[signal |exit status |[\w./*()\[\]-]+\(.*\)$)`)
+	if err != nil {
+		panic(fmt.Errorf("failed to compile regex: %v", err))
+	}
+}
+
+// Excerpt is the relevant part of a container logs
+type Excerpt struct {
+	Text        string
+	LikelyCause string
+}
+
+type block struct {
+	start int
+	end   int
+	kind  signatureKind
+	cause string
+}
+
+// Extract finds error signatures in the logs and returns the blocks around them, bounded by byteBudget.
+// When no signature is found, the tail of the logs is returned.
+// A non-positive byteBudget disables excerpting and the logs are returned as is, with the likely cause still detected.
+func Extract(logs string, byteBudget int) Excerpt {
+	lines := strings.Split(logs, "\n")
+	// signatures and stacks are matched on the lines content, while the excerpt keeps the timestamps
+	blocks := findBlocks(withoutTimestamps(lines))
+
+	var excerpt Excerpt
+	causeIndex := likelyCauseIndex(blocks)
+	if causeIndex >= 0 {
+		excerpt.LikelyCause = truncateLine(blocks[causeIndex].cause, maxCauseLength)
+	}
+
+	if byteBudget <= 0 {
+		excerpt.Text = logs
+		return excerpt
+	}
+
+	if len(blocks) == 0 {
+		excerpt.Text = tail(lines, byteBudget)
+		return excerpt
+	}
+
+	excerpt.Text = assemble(lines, blocks, causeIndex, byteBudget)
+	return excerpt
+}
+
+func withoutTimestamps(lines []string) []string {
+	contents := make([]string, len(lines))
+	for i, line := range lines {
+		contents[i] = timestampPrefixRegex.ReplaceAllString(line, "")
+	}
+	return contents
+}
+
+func matchSignature(line string) (*signature, bool) {
+	for _, sig := range signatures {
+		if sig.regex.MatchString(line) {
+			return sig, true
+		}
+	}
+	return nil, false
+}
+
+func isStackLine(line string) bool {
+	return stackLineRegex.MatchString(line)
+}
+
+func findBlocks(lines []string) (blocks []*block) {
+	for i := 0; i < len(lines); i++ {
+		sig, found := matchSignature(lines[i])
+		if !found {
+			continue
+		}
+		b := &block{
+			start: i,
+			end:   i,
+			kind:  sig.kind,
+			cause: strings.TrimSpace(lines[i]),
+		}
+		switch sig.kind {
+		case goPanic, pythonTraceback, javaException:
+			b.end = extendStack(lines, i)
+			if sig.kind == pythonTraceback && b.end+1 < len(lines) && strings.TrimSpace(lines[b.end+1]) != "" {
+				// the exception line follows the indented frames
+				b.end++
+				b.cause = strings.TrimSpace(lines[b.end])
+			}
+			if sig.kind == javaException {
+				for j := b.start; j <= b.end; j++ {
+					if strings.HasPrefix(strings.TrimSpace(lines[j]), "Caused by: ") {
+						b.cause = strings.TrimSpace(lines[j])
+					}
+				}
+			}
+		}
+		blocks = append(blocks, b)
+		i = b.end
+	}
+	return
+}
+
+func extendStack(lines []string, start int) (end int) {
+	end = start
+	for j := start + 1; j < len(lines) && j-start < maxBlockLines; j++ {
+		line := lines[j]
+		if strings.TrimSpace(line) == "" {
+			// a blank line is part of the stack only if the stack continues after it
+			if j+1 < len(lines) && isStackLine(lines[j+1]) {
+				continue
+			}
+			return
+		}
+		if !isStackLine(line) {
+			return
+		}
+		end = j
+	}
+	return
+}
+
+func likelyCauseIndex(blocks []*block) int {
+	index := -1
+	for i, b := range blocks {
+		// ties are resolved in favor of the most recent block
+		if index == -1 || kindToRank[b.kind] <= kindToRank[blocks[index].kind] {
+			index = i
+		}
+	}
+	return index
+}
+
+type lineRange struct {
+	start int
+	end   int
+}
+
+func (r lineRange) text(lines []string) string {
+	return strings.Join(lines[r.start:r.end+1], "\n")
+}
+
+func withContext(b *block, linesCount int) lineRange {
+	start := b.start - contextLines
+	if start < 0 {
+		start = 0
+	}
+	end := b.end + contextLines
+	if end >= linesCount {
+		end = linesCount - 1
+	}
+	return lineRange{start: start, end: end}
+}
+
+func assemble(lines []string, blocks []*block, causeIndex int, byteBudget int) string {
+	// the likely cause block goes first, then the rest from the most recent backwards, as long as budget allows
+	priority := []int{causeIndex}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if i != causeIndex {
+			priority = append(priority, i)
+		}
+	}
+
+	// the budget is checked against the assembled text, as adding a block may add separators or bridge a gap
+	selected := make([]bool, len(lines))
+	for n, i := range priority {
+		r := withContext(blocks[i], len(lines))
+		candidate := append([]bool(nil), selected...)
+		for j := r.start; j <= r.end; j++ {
+			candidate[j] = true
+		}
+		if len(render(lines, candidate)) > byteBudget {
+			if n == 0 {
+				// not even the likely cause fits with its context, settle for the bare block
+				return truncateText(lineRange{start: blocks[i].start, end: blocks[i].end}.text(lines), byteBudget)
+			}
+			continue
+		}
+		selected = candidate
+	}
+	return render(lines, selected)
+}
+
+// render the selected lines, where each run of consecutive lines is a part and parts are separated by blocksSeparator
+func render(lines []string, selected []bool) string {
+	var parts []string
+	for j := 0; j < len(lines); {
+		if !selected[j] {
+			j++
+			continue
+		}
+		r := lineRange{start: j, end: j}
+		for r.end+1 < len(lines) && selected[r.end+1] {
+			r.end++
+		}
+		parts = append(parts, r.text(lines))
+		j = r.end + 1
+	}
+	return strings.TrimSpace(strings.Join(parts, blocksSeparator))
+}
+
+func tail(lines []string, byteBudget int) string {
+	used := 0
+	start := len(lines)
+	for start > 0 && used+len(lines[start-1])+1 <= byteBudget {
+		start--
+		used += len(lines[start]) + 1
+	}
+	if start == len(lines) {
+		return truncateText(lines[len(lines)-1], byteBudget)
+	}
+	return strings.Join(lines[start:], "\n")
+}
+
+func truncateText(text string, byteBudget int) string {
+	if len(text) <= byteBudget {
+		return text
+	}
+	cut := text[:runeBoundary(text, byteBudget)]
+	if newline := strings.LastIndex(cut, "\n"); newline > 0 {
+		cut = cut[:newline]
+	}
+	return cut
+}
+
+func truncateLine(line string, maxLength int) string {
+	if len(line) <= maxLength {
+		return line
+	}
+	return line[:runeBoundary(line, maxLength)] + "..."
+}
+
+// runeBoundary backs off from index to the start of the rune it falls within, so a cut does not split a rune
+func runeBoundary(text string, index int) int {
+	for index > 0 && index < len(text) && !utf8.RuneStart(text[index]) {
+		index--
+	}
+	return index
+}
@@ -0,0 +1,204 @@
+package excerpt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtract_NoSignatures(t *testing.T) {
+	logs := "starting\nlistening on :8080\nshutting down"
+	excerpt := Extract(logs, 1024)
+	assert.Equal(t, logs, excerpt.Text)
+	assert.Equal(t, "", excerpt.LikelyCause)
+
+	excerpt = Extract(logs, 20)
+	assert.Equal(t, "shutting down", excerpt.Text)
+}
+
+func TestExtract_DisabledBudget(t *testing.T) {
+	logs := "a\nb\npanic: boom\nc"
+	excerpt := Extract(logs, 0)
+	assert.Equal(t, logs, excerpt.Text)
+	assert.Equal(t, "panic: boom", excerpt.LikelyCause)
+}
+
+func TestExtract_GoPanic(t *testing.T) {
+	logs := strings.Join([]string{
+		"line 1",
+		"line 2",
+		"line 3",
+		"line 4",
+		"panic: runtime error: invalid memory address or nil pointer dereference",
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4553a2]",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/app/main.go:12 +0x1d",
+		"exit status 2",
+		"shutdown complete",
+		"bye",
+		"line 5",
+	}, "\n")
+	excerpt := Extract(logs, 4096)
+	assert.Equal(t, "panic: runtime error: invalid memory address or nil pointer dereference", excerpt.LikelyCause)
+	assert.Equal(t, strings.Join([]string{
+		"line 3",
+		"line 4",
+		"panic: runtime error: invalid memory address or nil pointer dereference",
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4553a2]",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/app/main.go:12 +0x1d",
+		"exit status 2",
+		"shutdown complete",
+		"bye",
+	}, "\n"), excerpt.Text)
+}
+
+func TestExtract_PythonTraceback(t *testing.T) {
+	logs := strings.Join([]string{
+		"INFO starting",
+		"Traceback (most recent call last):",
+		"  File \"app.py\", line 3, in <module>",
+		"    main()",
+		"  File \"app.py\", line 2, in main",
+		"    raise ValueError('bad config')",
+		"ValueError: bad config",
+	}, "\n")
+	excerpt := Extract(logs, 4096)
+	assert.Equal(t, "ValueError: bad config", excerpt.LikelyCause)
+	assert.Equal(t, logs, excerpt.Text)
+}
+
+func TestExtract_JavaException(t *testing.T) {
+	logs := strings.Join([]string{
+		"Exception in thread \"main\" java.lang.IllegalStateException: failed to start",
+		"\tat com.example.App.start(App.java:10)",
+		"\tat com.example.App.main(App.java:5)",
+		"Caused by: java.net.ConnectException: Connection refused",
+		"\tat java.net.Socket.connect(Socket.java:591)",
+		"\t... 2 more",
+	}, "\n")
+	excerpt := Extract(logs, 4096)
+	assert.Equal(t, "Caused by: java.net.ConnectException: Connection refused", excerpt.LikelyCause)
+	assert.Equal(t, logs, excerpt.Text)
+}
+
+func TestExtract_PrefersMostSevereAndMostRecent(t *testing.T) {
+	logs := strings.Join([]string{
+		"ERROR failed to connect",
+		"a",
+		"b",
+		"c",
+		"d",
+		"e",
+		"FATAL giving up",
+		"f",
+		"g",
+		"h",
+		"i",
+		"j",
+		"ERROR another failure",
+	}, "\n")
+	excerpt := Extract(logs, 4096)
+	assert.Equal(t, "FATAL giving up", excerpt.LikelyCause)
+	assert.Equal(t, strings.Join([]string{
+		"ERROR failed to connect",
+		"a",
+		"b",
+		"...",
+		"d",
+		"e",
+		"FATAL giving up",
+		"f",
+		"g",
+		"...",
+		"i",
+		"j",
+		"ERROR another failure",
+	}, "\n"), excerpt.Text)
+}
+
+func TestExtract_OutOfMemory(t *testing.T) {
+	excerpt := Extract("loading\nruntime: out of memory: cannot allocate 1048576-byte block\ndone", 4096)
+	assert.Equal(t, "runtime: out of memory: cannot allocate 1048576-byte block", excerpt.LikelyCause)
+}
+
+func TestExtract_Budget(t *testing.T) {
+	logs := strings.Join([]string{
+		"ERROR first",
+		"1",
+		"2",
+		"3",
+		"4",
+		"5",
+		"ERROR second",
+	}, "\n")
+	excerpt := Extract(logs, 20)
+	assert.Equal(t, "ERROR second", excerpt.LikelyCause)
+	assert.Equal(t, "4\n5\nERROR second", excerpt.Text)
+	assert.LessOrEqual(t, len(excerpt.Text), 20)
+
+	excerpt = Extract(logs, 14)
+	assert.Equal(t, "ERROR second", excerpt.Text)
+}
+
+func TestExtract_BudgetCountsSeparators(t *testing.T) {
+	logs := strings.Join([]string{
+		"ERROR first",
+		"1",
+		"2",
+		"3",
+		"4",
+		"5",
+		"6",
+		"ERROR second",
+	}, "\n")
+	// both blocks with their context are 31 bytes, and 36 bytes along with the separator between them
+	excerpt := Extract(logs, 35)
+	assert.Equal(t, "5\n6\nERROR second", excerpt.Text)
+
+	excerpt = Extract(logs, 36)
+	assert.Equal(t, "ERROR first\n1\n2\n...\n5\n6\nERROR second", excerpt.Text)
+	assert.Equal(t, 36, len(excerpt.Text))
+}
+
+func TestExtract_RuneBoundaries(t *testing.T) {
+	excerpt := Extract("panic: שגיאה", 10)
+	assert.True(t, utf8.ValidString(excerpt.Text), excerpt.Text)
+	assert.Equal(t, "panic: ש", excerpt.Text)
+
+	cause := "ERROR " + strings.Repeat("ש", 200)
+	excerpt = Extract(cause, 4096)
+	assert.True(t, utf8.ValidString(excerpt.LikelyCause))
+	assert.True(t, strings.HasSuffix(excerpt.LikelyCause, "ש..."))
+}
+
+func TestExtract_Timestamps(t *testing.T) {
+	logs := strings.Join([]string{
+		"2021-10-17T14:00:00.000000001Z starting",
+		"2021-10-17T14:00:01.000000001Z panic: boom",
+		"2021-10-17T14:00:01.000000002Z",
+		"2021-10-17T14:00:01.000000003Z goroutine 1 [running]:",
+		"2021-10-17T14:00:01.000000004Z main.main()",
+		"2021-10-17T14:00:01.000000005Z \t/app/main.go:12 +0x1d",
+		"2021-10-17T14:00:02.000000001Z restarting",
+		"2021-10-17T14:00:03.000000001Z listening",
+		"2021-10-17T14:00:04.000000001Z ready",
+	}, "\n")
+	excerpt := Extract(logs, 4096)
+	assert.Equal(t, "panic: boom", excerpt.LikelyCause, "the likely cause has no timestamp")
+	assert.Equal(t, strings.Join([]string{
+		"2021-10-17T14:00:00.000000001Z starting",
+		"2021-10-17T14:00:01.000000001Z panic: boom",
+		"2021-10-17T14:00:01.000000002Z",
+		"2021-10-17T14:00:01.000000003Z goroutine 1 [running]:",
+		"2021-10-17T14:00:01.000000004Z main.main()",
+		"2021-10-17T14:00:01.000000005Z \t/app/main.go:12 +0x1d",
+		"2021-10-17T14:00:02.000000001Z restarting",
+		"2021-10-17T14:00:03.000000001Z listening",
+	}, "\n"), excerpt.Text, "the stack is detected across timestamped lines")
+}
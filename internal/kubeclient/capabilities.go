@@ -0,0 +1,78 @@
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	log "github.com/sirupsen/logrus"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+)
+
+const crdsGroupVersion = "apiextensions.k8s.io/v1"
+
+// CRDs are listed as metadata only, their schemas may be large
+const metadataOnlyAccept = "application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1,application/json"
+
+// mockGroupVersions are served by the mock client unless set otherwise
+var mockGroupVersions = []string{
+	"v1",
+	"apps/v1",
+	"autoscaling/v2",
+	"events.k8s.io/v1",
+	"metrics.k8s.io/v1beta1",
+}
+
+// GetCapabilities detects the served group versions with the discovery api, and the installed CRDs if permitted to list them
+func (client *remoteKubernetesClient) GetCapabilities(ctx context.Context) (*alert.ClusterCapabilities, error) {
+	groups, err := client.kubeClientSet.Discovery().ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover api groups: %w", err)
+	}
+
+	capabilities := &alert.ClusterCapabilities{}
+	for _, group := range groups.Groups {
+		for _, version := range group.Versions {
+			capabilities.GroupVersions = append(capabilities.GroupVersions, version.GroupVersion)
+		}
+	}
+	sort.Strings(capabilities.GroupVersions)
+
+	if capabilities.Serves(crdsGroupVersion) {
+		capabilities.CRDs, err = client.getCRDNames(ctx)
+		if err != nil {
+			log.Debugf("Installed CRDs are unknown: %v", err)
+		}
+	}
+
+	client.capabilities = capabilities
+	return capabilities, nil
+}
+
+func (client *remoteKubernetesClient) getCRDNames(ctx context.Context) ([]string, error) {
+	restClient := client.kubeClientSet.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, fmt.Errorf("no rest client to list CRDs with")
+	}
+	content, err := restClient.Get().
+		AbsPath("/apis", crdsGroupVersion, "customresourcedefinitions").
+		SetHeader("Accept", metadataOnlyAccept).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CRDs: %w", err)
+	}
+
+	var list metaV1.PartialObjectMetadataList
+	err = json.Unmarshal(content, &list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize CRDs list: %v", err)
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,49 @@
+package kubeclient
+
+import (
+	"context"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func TestRemoteClient_GetCapabilities(t *testing.T) {
+	cfg, err := config.DefaultConfig()
+	require.Nil(t, err)
+
+	lastRun := asTime("2021-10-17T14:00:00Z")
+	clientSet := fake.NewSimpleClientset(
+		&v1.Event{
+			ObjectMeta:    metaV1.ObjectMeta{Namespace: "default", Name: "core-warning"},
+			Type:          v1.EventTypeWarning,
+			LastTimestamp: metaV1.NewTime(lastRun.Add(time.Minute)),
+		},
+		eventV1("default", "new-warning", v1.EventTypeWarning, lastRun.Add(time.Minute)),
+	)
+	clientSet.Resources = []*metaV1.APIResourceList{
+		{GroupVersion: "v1"},
+		{GroupVersion: "apps/v1"},
+		{GroupVersion: "metrics.k8s.io/v1beta1"},
+	}
+	client := &remoteKubernetesClient{
+		kubeClientSet: clientSet,
+		config:        cfg,
+	}
+
+	capabilities, err := client.GetCapabilities(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []string{"apps/v1", "metrics.k8s.io/v1beta1", "v1"}, capabilities.GroupVersions)
+	assert.True(t, capabilities.Serves("metrics.k8s.io/v1beta1"))
+	assert.False(t, capabilities.Serves("events.k8s.io/v1"))
+	assert.Empty(t, capabilities.CRDs)
+
+	events, err := client.GetEvents(context.Background(), "default", lastRun)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(events), "events are listed with the core api when events.k8s.io is not served")
+	assert.Equal(t, "core-warning", events[0].Name)
+}
@@ -3,13 +3,16 @@ package kubeclient
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/config"
 	"github.com/reallyliri/kubescout/internal/kubeconfig"
 	log "github.com/sirupsen/logrus"
 	"io"
 	v12 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
@@ -17,30 +20,49 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"strings"
+	"time"
 )
 
 type KubernetesClient interface {
-	GetNodes() ([]v1.Node, error)
-	GetNamespaces() ([]v1.Namespace, error)
-	GetPods(namespace string) ([]v1.Pod, error)
-	GetReplicaSets(namespace string) ([]v12.ReplicaSet, error)
-	GetPodLogs(namespace string, podName string, containerName string) (logs string, err error)
-	GetEvents(namespace string) ([]v1.Event, error)
+	GetNodes(ctx context.Context) ([]v1.Node, error)
+	GetNamespaces(ctx context.Context) ([]v1.Namespace, error)
+	GetPods(ctx context.Context, namespace string) ([]v1.Pod, error)
+	GetReplicaSets(ctx context.Context, namespace string) ([]v12.ReplicaSet, error)
+	// GetPodLogs gets the tail of a container logs, written since the given time unless it is zero
+	GetPodLogs(ctx context.Context, namespace string, podName string, containerName string, since time.Time) (logs string, err error)
+	// GetEvents lists non normal events, seen since the given time unless it is zero
+	GetEvents(ctx context.Context, namespace string, since time.Time) ([]v1.Event, error)
+	// GetCapabilities detects the apis served by the cluster
+	GetCapabilities(ctx context.Context) (*alert.ClusterCapabilities, error)
+	// GetWorkloadAnnotations gets the annotations of a pod controller which is not listed, i.e. a stateful set,
+	// daemon set or job. Annotations of other kinds, or of a controller that no longer exists, are nil.
+	GetWorkloadAnnotations(ctx context.Context, namespace string, kind string, name string) (map[string]string, error)
 }
 
 type remoteKubernetesClient struct {
-	kubeClientSet *kubernetes.Clientset
+	kubeClientSet kubernetes.Interface
 	config        *config.Config
+	// detected capabilities, or nil if not detected yet
+	capabilities *alert.ClusterCapabilities
 }
 
 var _ KubernetesClient = &remoteKubernetesClient{}
 
-func CreateClient(config *config.Config, kubeconfig kubeconfig.KubeConfig) (KubernetesClient, error) {
+func buildClientSet(config *config.Config, kubeconfig kubeconfig.KubeConfig) (*kubernetes.Clientset, error) {
+	kconf, err := buildRestConfig(config, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return newClientSet(kconf)
+}
+
+func buildRestConfig(config *config.Config, kubeconfig kubeconfig.KubeConfig) (*rest.Config, error) {
 
 	var kconf *rest.Config
 	var err error
 
-	if config.RunningInCluster {
+	// clusters of the hub secrets have their own kubeconfig, even when running in cluster
+	if config.RunningInCluster && kubeconfig == nil {
 		kconf, err = rest.InClusterConfig()
 		if err != nil {
 			return nil, fmt.Errorf("failed to build kubeconfig from in cluster token: %v", err)
@@ -56,10 +78,25 @@ func CreateClient(config *config.Config, kubeconfig kubeconfig.KubeConfig) (Kube
 		}
 	}
 
+	kconf.QPS = config.KubeAPIQPS
+	kconf.Burst = config.KubeAPIBurst
+	kconf.Timeout = config.RequestTimeout
+	return kconf, nil
+}
+
+func newClientSet(kconf *rest.Config) (*kubernetes.Clientset, error) {
 	clientSet, err := kubernetes.NewForConfig(kconf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
 	}
+	return clientSet, nil
+}
+
+func CreateClient(config *config.Config, kubeconfig kubeconfig.KubeConfig) (KubernetesClient, error) {
+	clientSet, err := buildClientSet(config, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
 
 	return &remoteKubernetesClient{
 		kubeClientSet: clientSet,
@@ -67,14 +104,14 @@ func CreateClient(config *config.Config, kubeconfig kubeconfig.KubeConfig) (Kube
 	}, nil
 }
 
-func (client *remoteKubernetesClient) GetNodes() ([]v1.Node, error) {
+func (client *remoteKubernetesClient) GetNodes(ctx context.Context) ([]v1.Node, error) {
 	var nodes []v1.Node
 	err := pagedGet(
 		nil,
 		func(options metaV1.ListOptions) (runtime.Object, error) {
-			newNodes, err := client.kubeClientSet.CoreV1().Nodes().List(context.Background(), options)
+			newNodes, err := client.kubeClientSet.CoreV1().Nodes().List(ctx, options)
 			if err != nil {
-				return nil, fmt.Errorf("failed to list nodes: %v", err)
+				return nil, fmt.Errorf("failed to list nodes: %w", err)
 			}
 			nodes = append(nodes, newNodes.Items...)
 			return newNodes, nil
@@ -83,14 +120,14 @@ func (client *remoteKubernetesClient) GetNodes() ([]v1.Node, error) {
 	return nodes, err
 }
 
-func (client *remoteKubernetesClient) GetNamespaces() ([]v1.Namespace, error) {
+func (client *remoteKubernetesClient) GetNamespaces(ctx context.Context) ([]v1.Namespace, error) {
 	var namespaces []v1.Namespace
 	err := pagedGet(
 		nil,
 		func(options metaV1.ListOptions) (runtime.Object, error) {
-			newNamespaces, err := client.kubeClientSet.CoreV1().Namespaces().List(context.Background(), options)
+			newNamespaces, err := client.kubeClientSet.CoreV1().Namespaces().List(ctx, options)
 			if err != nil {
-				return nil, fmt.Errorf("failed to list namespaces: %v", err)
+				return nil, fmt.Errorf("failed to list namespaces: %w", err)
 			}
 			namespaces = append(namespaces, newNamespaces.Items...)
 			return newNamespaces, nil
@@ -99,14 +136,22 @@ func (client *remoteKubernetesClient) GetNamespaces() ([]v1.Namespace, error) {
 	return namespaces, err
 }
 
-func (client *remoteKubernetesClient) GetPods(namespace string) ([]v1.Pod, error) {
+// workloadListOptions filters listed workloads server side by the configured label selector
+func (client *remoteKubernetesClient) workloadListOptions() *metaV1.ListOptions {
+	return &metaV1.ListOptions{
+		Limit:         pageSize,
+		LabelSelector: client.config.WorkloadSelector,
+	}
+}
+
+func (client *remoteKubernetesClient) GetPods(ctx context.Context, namespace string) ([]v1.Pod, error) {
 	var pods []v1.Pod
 	err := pagedGet(
-		nil,
+		client.workloadListOptions(),
 		func(options metaV1.ListOptions) (runtime.Object, error) {
-			newPods, err := client.kubeClientSet.CoreV1().Pods(namespace).List(context.Background(), options)
+			newPods, err := client.kubeClientSet.CoreV1().Pods(namespace).List(ctx, options)
 			if err != nil {
-				return nil, fmt.Errorf("failed to list pods in namespace '%v': %v", namespace, err)
+				return nil, fmt.Errorf("failed to list pods in namespace '%v': %w", namespace, err)
 			}
 			pods = append(pods, newPods.Items...)
 			return newPods, nil
@@ -115,14 +160,14 @@ func (client *remoteKubernetesClient) GetPods(namespace string) ([]v1.Pod, error
 	return pods, err
 }
 
-func (client *remoteKubernetesClient) GetReplicaSets(namespace string) ([]v12.ReplicaSet, error) {
+func (client *remoteKubernetesClient) GetReplicaSets(ctx context.Context, namespace string) ([]v12.ReplicaSet, error) {
 	var replicaSets []v12.ReplicaSet
 	err := pagedGet(
-		nil,
+		client.workloadListOptions(),
 		func(options metaV1.ListOptions) (runtime.Object, error) {
-			newReplicaSets, err := client.kubeClientSet.AppsV1().ReplicaSets(namespace).List(context.Background(), options)
+			newReplicaSets, err := client.kubeClientSet.AppsV1().ReplicaSets(namespace).List(ctx, options)
 			if err != nil {
-				return nil, fmt.Errorf("failed to list replicaSets for namespace '%v': %v", namespace, err)
+				return nil, fmt.Errorf("failed to list replicaSets for namespace '%v': %w", namespace, err)
 			}
 			replicaSets = append(replicaSets, newReplicaSets.Items...)
 			return newReplicaSets, nil
@@ -131,35 +176,105 @@ func (client *remoteKubernetesClient) GetReplicaSets(namespace string) ([]v12.Re
 	return replicaSets, err
 }
 
-func (client *remoteKubernetesClient) GetEvents(namespace string) ([]v1.Event, error) {
+func (client *remoteKubernetesClient) GetWorkloadAnnotations(ctx context.Context, namespace string, kind string, name string) (map[string]string, error) {
+	var object metaV1.Object
+	var err error
+	switch kind {
+	case "StatefulSet":
+		object, err = client.kubeClientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, metaV1.GetOptions{})
+	case "DaemonSet":
+		object, err = client.kubeClientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, metaV1.GetOptions{})
+	case "Job":
+		object, err = client.kubeClientSet.BatchV1().Jobs(namespace).Get(ctx, name, metaV1.GetOptions{})
+	default:
+		return nil, nil
+	}
+	if apiErrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %v %v/%v: %w", kind, namespace, name, err)
+	}
+	return object.GetAnnotations(), nil
+}
+
+// GetEvents lists the non normal events seen since the given time, using the events.k8s.io api if served
+func (client *remoteKubernetesClient) GetEvents(ctx context.Context, namespace string, since time.Time) ([]v1.Event, error) {
+	if !client.capabilities.Serves("events.k8s.io/v1") {
+		return client.getCoreEvents(ctx, namespace, since)
+	}
+	listOptions := metaV1.ListOptions{
+		Limit:         client.config.EventsLimit,
+		FieldSelector: eventsFieldSelector,
+	}
+	collector := newEventsCollector(since, client.config.EventsLimit)
+	err := pagedGet(
+		&listOptions,
+		func(options metaV1.ListOptions) (runtime.Object, error) {
+			newEvents, err := client.kubeClientSet.EventsV1().Events(namespace).List(ctx, options)
+			if err != nil {
+				return nil, err
+			}
+			for _, event := range newEvents.Items {
+				collector.add(fromEventsV1(&event))
+			}
+			if collector.full(namespace) {
+				return nil, errStopPaging
+			}
+			return newEvents, nil
+		},
+	)
+	if apiErrors.IsNotFound(err) {
+		log.Debugf("events.k8s.io api is not served, falling back to core events api: %v", err)
+		return client.getCoreEvents(ctx, namespace, since)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get events for %v: %w", namespace, err)
+	}
+	return collector.events, nil
+}
+
+func (client *remoteKubernetesClient) getCoreEvents(ctx context.Context, namespace string, since time.Time) ([]v1.Event, error) {
 	listOptions := metaV1.ListOptions{
-		Limit: client.config.EventsLimit,
+		Limit:         client.config.EventsLimit,
+		FieldSelector: eventsFieldSelector,
 	}
-	var eventList []v1.Event
+	collector := newEventsCollector(since, client.config.EventsLimit)
 	err := pagedGet(
 		&listOptions,
 		func(options metaV1.ListOptions) (runtime.Object, error) {
-			newEvents, err := client.kubeClientSet.CoreV1().Events(namespace).List(context.Background(), options)
+			newEvents, err := client.kubeClientSet.CoreV1().Events(namespace).List(ctx, options)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get events for %v: %v", namespace, err)
+				return nil, fmt.Errorf("failed to get events for %v: %w", namespace, err)
+			}
+			for _, event := range newEvents.Items {
+				collector.add(event)
+			}
+			if collector.full(namespace) {
+				return nil, errStopPaging
 			}
-			eventList = append(eventList, newEvents.Items...)
 			return newEvents, nil
 		},
 	)
-	return eventList, err
+	return collector.events, err
 }
 
-func (client *remoteKubernetesClient) GetPodLogs(namespace string, podName string, containerName string) (logs string, err error) {
+func (client *remoteKubernetesClient) GetPodLogs(ctx context.Context, namespace string, podName string, containerName string, since time.Time) (logs string, err error) {
 	if client.config.PodLogsTail == 0 {
 		return "", nil
 	}
 
-	logsRequest := client.kubeClientSet.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{
-		TailLines: &client.config.PodLogsTail,
-		Container: containerName,
-	})
-	stream, err := logsRequest.Stream(context.Background())
+	logOptions := &v1.PodLogOptions{
+		TailLines:  &client.config.PodLogsTail,
+		Container:  containerName,
+		Timestamps: true,
+	}
+	if !since.IsZero() {
+		sinceTime := metaV1.NewTime(since)
+		logOptions.SinceTime = &sinceTime
+	}
+	logsRequest := client.kubeClientSet.CoreV1().Pods(namespace).GetLogs(podName, logOptions)
+	stream, err := logsRequest.Stream(ctx)
 	if err != nil {
 		if strings.Contains(err.Error(), "waiting to start") {
 			return "", nil
@@ -173,12 +288,11 @@ func (client *remoteKubernetesClient) GetPodLogs(namespace string, podName strin
 		}
 	}()
 
-	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, stream)
+	// the limit is not sent as LimitBytes, as the server would keep the head of the logs rather than their tail
+	logs, err = readTail(stream, client.config.LogsLimitBytes)
 	if err != nil {
 		return "", fmt.Errorf("error in stream copy for %v/%v/%v : %v", namespace, podName, containerName, err)
 	}
-	logs = buf.String()
 	if strings.HasPrefix(logs, "unable to retrieve container logs for") ||
 		strings.HasPrefix(logs, "failed to try resolving symlinks in path") {
 		log.Infof("failed to retrieve logs of %v/%v/%v : %v", namespace, podName, containerName, logs)
@@ -186,3 +300,51 @@ func (client *remoteKubernetesClient) GetPodLogs(namespace string, podName strin
 	}
 	return logs, nil
 }
+
+// readTail reads to the end and returns the last limit bytes, starting at a line, or everything if limit is not positive.
+// Memory is bounded by twice the limit plus a read chunk of at most 32KB, however long the stream is.
+func readTail(reader io.Reader, limit int64) (string, error) {
+	if limit <= 0 {
+		buf := new(bytes.Buffer)
+		_, err := io.Copy(buf, reader)
+		return buf.String(), err
+	}
+
+	chunkSize := int64(32 * 1024)
+	if limit < chunkSize {
+		chunkSize = limit
+	}
+	tail := make([]byte, 0, 2*limit+chunkSize)
+	chunk := make([]byte, chunkSize)
+	trimmed := false
+	startsLine := true
+	trim := func() {
+		cut := int64(len(tail)) - limit
+		trimmed = true
+		startsLine = tail[cut-1] == '\n'
+		tail = append(tail[:0], tail[cut:]...)
+	}
+	for {
+		n, err := reader.Read(chunk)
+		tail = append(tail, chunk[:n]...)
+		if int64(len(tail)) > 2*limit {
+			trim()
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if int64(len(tail)) > limit {
+		trim()
+	}
+	if trimmed && !startsLine {
+		// the first line is partial
+		if newline := bytes.IndexByte(tail, '\n'); newline >= 0 {
+			tail = tail[newline+1:]
+		}
+	}
+	return string(tail), nil
+}
@@ -1,13 +1,20 @@
 package kubeclient
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/config"
 	"io/ioutil"
 	v12 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"os"
+	"time"
 )
 
 type mockKubernetesClient struct {
@@ -16,30 +23,149 @@ type mockKubernetesClient struct {
 	pods        *v1.PodList
 	replicaSets *v12.ReplicaSetList
 	events      *v1.EventList
+	// annotations of stateful sets, daemon sets and jobs, by workloadKey
+	workloadAnnotations map[string]map[string]string
+	// the workload selector is read from the config like the remote client does, nil for no config
+	config *config.Config
+	// served group versions, or the mock defaults if nil
+	capabilities *alert.ClusterCapabilities
+	// mimics a namespace scoped service account
+	forbidClusterScope bool
+	// listings which fail as forbidden, by forbiddenKey
+	forbidden map[string]bool
 }
 
-func (client *mockKubernetesClient) GetNodes() ([]v1.Node, error) {
+// ForbidClusterScope makes listing namespaces, nodes or any kind across all namespaces fail as forbidden
+func (client *mockKubernetesClient) ForbidClusterScope() {
+	client.forbidClusterScope = true
+}
+
+func forbiddenKey(resource string, namespace string) string {
+	return resource + "/" + namespace
+}
+
+// Forbid makes listing the resource in the namespace fail as forbidden, or across all namespaces if the namespace is empty
+func (client *mockKubernetesClient) Forbid(resource string, namespace string) {
+	if client.forbidden == nil {
+		client.forbidden = map[string]bool{}
+	}
+	client.forbidden[forbiddenKey(resource, namespace)] = true
+}
+
+func (client *mockKubernetesClient) failure(ctx context.Context, resource string, namespace string) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("failed to list %v: %w", resource, ctx.Err())
+	}
+	if client.forbidClusterScope && namespace == "" {
+		return apiErrors.NewForbidden(schema.GroupResource{Resource: resource}, "", fmt.Errorf("cluster scope is forbidden"))
+	}
+	if client.forbidden[forbiddenKey(resource, namespace)] {
+		return apiErrors.NewForbidden(schema.GroupResource{Resource: resource}, "", fmt.Errorf("listing %v in '%v' is forbidden", resource, namespace))
+	}
+	return nil
+}
+
+// workloadSelector mimics the server side filtering of pods and replica sets by the configured label selector
+func (client *mockKubernetesClient) workloadSelector() (labels.Selector, error) {
+	if client.config == nil {
+		return labels.Everything(), nil
+	}
+	selector, err := labels.Parse(client.config.WorkloadSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workload selector '%v': %v", client.config.WorkloadSelector, err)
+	}
+	return selector, nil
+}
+
+func (client *mockKubernetesClient) GetNodes(ctx context.Context) ([]v1.Node, error) {
+	if err := client.failure(ctx, "nodes", ""); err != nil {
+		return nil, err
+	}
 	return client.nodes.Items, nil
 }
 
-func (client *mockKubernetesClient) GetNamespaces() ([]v1.Namespace, error) {
+func (client *mockKubernetesClient) GetNamespaces(ctx context.Context) ([]v1.Namespace, error) {
+	if err := client.failure(ctx, "namespaces", ""); err != nil {
+		return nil, err
+	}
 	return client.namespaces.Items, nil
 }
 
-func (client *mockKubernetesClient) GetPods(namespace string) ([]v1.Pod, error) {
-	return client.pods.Items, nil
+func (client *mockKubernetesClient) GetPods(ctx context.Context, namespace string) ([]v1.Pod, error) {
+	if err := client.failure(ctx, "pods", namespace); err != nil {
+		return nil, err
+	}
+	selector, err := client.workloadSelector()
+	if err != nil {
+		return nil, err
+	}
+	pods := []v1.Pod{}
+	for _, pod := range client.pods.Items {
+		if (namespace == "" || pod.Namespace == namespace) && selector.Matches(labels.Set(pod.Labels)) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
 }
 
-func (client *mockKubernetesClient) GetReplicaSets(namespace string) ([]v12.ReplicaSet, error) {
-	return client.replicaSets.Items, nil
+func (client *mockKubernetesClient) GetReplicaSets(ctx context.Context, namespace string) ([]v12.ReplicaSet, error) {
+	if err := client.failure(ctx, "replicasets", namespace); err != nil {
+		return nil, err
+	}
+	selector, err := client.workloadSelector()
+	if err != nil {
+		return nil, err
+	}
+	replicaSets := []v12.ReplicaSet{}
+	for _, replicaSet := range client.replicaSets.Items {
+		if (namespace == "" || replicaSet.Namespace == namespace) && selector.Matches(labels.Set(replicaSet.Labels)) {
+			replicaSets = append(replicaSets, replicaSet)
+		}
+	}
+	return replicaSets, nil
 }
 
-func (client *mockKubernetesClient) GetPodLogs(namespace string, podName string, containerName string) (string, error) {
+func (client *mockKubernetesClient) GetPodLogs(ctx context.Context, namespace string, podName string, containerName string, since time.Time) (string, error) {
 	return fmt.Sprintf("%v/%v/%v/logs", namespace, podName, containerName), nil
 }
 
-func (client *mockKubernetesClient) GetEvents(namespace string) ([]v1.Event, error) {
-	return client.events.Items, nil
+// GetEvents of the mock client filters events by time only, as tests rely on normal events and unlimited events
+func (client *mockKubernetesClient) GetEvents(ctx context.Context, namespace string, since time.Time) ([]v1.Event, error) {
+	if err := client.failure(ctx, "events", namespace); err != nil {
+		return nil, err
+	}
+	events := []v1.Event{}
+	for _, event := range client.events.Items {
+		if (namespace == "" || event.Namespace == namespace) && (since.IsZero() || !eventLastSeen(&event).Before(since)) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func workloadKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// SetWorkloadAnnotations mimics a stateful set, daemon set or job with the given annotations
+func (client *mockKubernetesClient) SetWorkloadAnnotations(kind string, namespace string, name string, annotations map[string]string) {
+	client.workloadAnnotations[workloadKey(kind, namespace, name)] = annotations
+}
+
+func (client *mockKubernetesClient) GetWorkloadAnnotations(ctx context.Context, namespace string, kind string, name string) (map[string]string, error) {
+	return client.workloadAnnotations[workloadKey(kind, namespace, name)], nil
+}
+
+// SetCapabilities mimics a cluster serving only the given apis
+func (client *mockKubernetesClient) SetCapabilities(capabilities *alert.ClusterCapabilities) {
+	client.capabilities = capabilities
+}
+
+func (client *mockKubernetesClient) GetCapabilities(ctx context.Context) (*alert.ClusterCapabilities, error) {
+	if client.capabilities != nil {
+		return client.capabilities, nil
+	}
+	return &alert.ClusterCapabilities{GroupVersions: mockGroupVersions}, nil
 }
 
 var _ KubernetesClient = &mockKubernetesClient{}
@@ -66,7 +192,9 @@ func fileRelevant(filePath string) bool {
 	return true
 }
 
+// CreateMockClient serves the objects of the given json files, filtered by the workload selector of the config if any
 func CreateMockClient(
+	config *config.Config,
 	nodesJsonFilePath string,
 	namespacesJsonFilePath string,
 	podsJsonFilePath string,
@@ -75,11 +203,13 @@ func CreateMockClient(
 ) (*mockKubernetesClient, error) {
 	var err error
 	client := &mockKubernetesClient{
-		nodes:       &v1.NodeList{},
-		namespaces:  &v1.NamespaceList{},
-		pods:        &v1.PodList{},
-		replicaSets: &v12.ReplicaSetList{},
-		events:      &v1.EventList{},
+		nodes:               &v1.NodeList{},
+		namespaces:          &v1.NamespaceList{},
+		pods:                &v1.PodList{},
+		replicaSets:         &v12.ReplicaSetList{},
+		events:              &v1.EventList{},
+		workloadAnnotations: map[string]map[string]string{},
+		config:              config,
 	}
 	if fileRelevant(nodesJsonFilePath) {
 		err = fromJson(nodesJsonFilePath, &client.nodes)
@@ -1,12 +1,14 @@
 package kubeclient
 
 import (
+	"context"
 	"github.com/stretchr/testify/require"
 	v12 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"path"
 	"runtime"
 	"testing"
+	"time"
 )
 
 var apiResponsesDirectoryPath string
@@ -18,6 +20,7 @@ func init() {
 
 func GetEvents(t *testing.T, fileName string) ([]v1.Event, error) {
 	client, err := CreateMockClient(
+		nil,
 		"",
 		"",
 		"",
@@ -27,12 +30,13 @@ func GetEvents(t *testing.T, fileName string) ([]v1.Event, error) {
 	require.Nil(t, err)
 	require.NotNil(t, client)
 
-	events, err := client.GetEvents("")
+	events, err := client.GetEvents(context.Background(), "", time.Time{})
 	return events, err
 }
 
 func GetNodes(t *testing.T, fileName string) ([]v1.Node, error) {
 	client, err := CreateMockClient(
+		nil,
 		path.Join(apiResponsesDirectoryPath, "get-nodes", fileName),
 		"",
 		"",
@@ -42,12 +46,13 @@ func GetNodes(t *testing.T, fileName string) ([]v1.Node, error) {
 	require.Nil(t, err)
 	require.NotNil(t, client)
 
-	nodes, err := client.GetNodes()
+	nodes, err := client.GetNodes(context.Background())
 	return nodes, err
 }
 
 func GetNamespaces(t *testing.T, fileName string) ([]v1.Namespace, error) {
 	client, err := CreateMockClient(
+		nil,
 		"",
 		path.Join(apiResponsesDirectoryPath, "get-ns", fileName),
 		"",
@@ -57,12 +62,13 @@ func GetNamespaces(t *testing.T, fileName string) ([]v1.Namespace, error) {
 	require.Nil(t, err)
 	require.NotNil(t, client)
 
-	namespaces, err := client.GetNamespaces()
+	namespaces, err := client.GetNamespaces(context.Background())
 	return namespaces, err
 }
 
 func GetPods(t *testing.T, fileName string) ([]v1.Pod, error) {
 	client, err := CreateMockClient(
+		nil,
 		"",
 		"",
 		path.Join(apiResponsesDirectoryPath, "get-pods", fileName),
@@ -72,12 +78,13 @@ func GetPods(t *testing.T, fileName string) ([]v1.Pod, error) {
 	require.Nil(t, err)
 	require.NotNil(t, client)
 
-	pods, err := client.GetPods("")
+	pods, err := client.GetPods(context.Background(), "")
 	return pods, err
 }
 
 func GetReplicaSets(t *testing.T, fileName string) ([]v12.ReplicaSet, error) {
 	client, err := CreateMockClient(
+		nil,
 		"",
 		"",
 		"",
@@ -87,6 +94,6 @@ func GetReplicaSets(t *testing.T, fileName string) ([]v12.ReplicaSet, error) {
 	require.Nil(t, err)
 	require.NotNil(t, client)
 
-	replicaSets, err := client.GetReplicaSets("")
+	replicaSets, err := client.GetReplicaSets(context.Background(), "")
 	return replicaSets, err
 }
@@ -0,0 +1,35 @@
+package kubeclient
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadTail(t *testing.T) {
+	logs := "first line\nsecond line\nthird line\n"
+
+	tail, err := readTail(strings.NewReader(logs), 0)
+	require.Nil(t, err)
+	assert.Equal(t, logs, tail)
+
+	tail, err = readTail(strings.NewReader(logs), int64(len(logs)))
+	require.Nil(t, err)
+	assert.Equal(t, logs, tail)
+
+	tail, err = readTail(strings.NewReader(logs), 16)
+	require.Nil(t, err)
+	assert.Equal(t, "third line\n", tail, "the partial line at the front is dropped")
+
+	tail, err = readTail(strings.NewReader(logs), 23)
+	require.Nil(t, err)
+	assert.Equal(t, "second line\nthird line\n", tail, "a tail starting at a line is kept whole")
+
+	// the tail is kept while reading a stream much longer than the limit
+	long := strings.Repeat("some log line\n", 100000) + "last line\n"
+	tail, err = readTail(iotest.HalfReader(strings.NewReader(long)), 30)
+	require.Nil(t, err)
+	assert.Equal(t, "some log line\nlast line\n", tail)
+}
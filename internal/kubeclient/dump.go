@@ -0,0 +1,293 @@
+package kubeclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/reallyliri/kubescout/config"
+	"io"
+	v12 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	eventsV1 "k8s.io/api/events/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DumpClient serves objects read from a cluster dump, as written by 'kubectl cluster-info dump --output-directory'
+// or 'kubectl get -o json', so a cluster can be diagnosed without any access to it
+type DumpClient struct {
+	*mockKubernetesClient
+	logsTail        int64
+	logsLimitBytes  int64
+	logsByContainer map[string]string
+	dumpTime        time.Time
+}
+
+var _ KubernetesClient = &DumpClient{}
+
+// a dump file holds either a list or a single object, in which case items are empty
+type dumpDocument struct {
+	metaV1.TypeMeta `json:",inline"`
+	Items           []json.RawMessage `json:"items"`
+}
+
+var containerLogsStartRegex = regexp.MustCompile(`^==== START logs for container (\S+) of pod (\S+)/(\S+) ====$`)
+var containerLogsEndRegex = regexp.MustCompile(`^==== END logs for container (\S+) of pod (\S+)/(\S+) ====$`)
+
+// CreateDumpClient reads all json and logs files under the dump directory of the config, logs are tailed to the
+// configured lines count and bytes limit, like the remote client does. So are pods and replica sets filtered by the
+// workload selector.
+func CreateDumpClient(config *config.Config) (*DumpClient, error) {
+	dirPath := config.DumpDirPath
+	client := &DumpClient{
+		mockKubernetesClient: &mockKubernetesClient{
+			nodes:               &v1.NodeList{},
+			namespaces:          &v1.NamespaceList{},
+			pods:                &v1.PodList{},
+			replicaSets:         &v12.ReplicaSetList{},
+			events:              &v1.EventList{},
+			workloadAnnotations: map[string]map[string]string{},
+			config:              config,
+		},
+		logsTail:        config.PodLogsTail,
+		logsLimitBytes:  config.LogsLimitBytes,
+		logsByContainer: map[string]string{},
+	}
+
+	err := filepath.Walk(dirPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch {
+		case strings.HasSuffix(filePath, ".json"):
+			return client.readObjects(filePath)
+		case strings.HasSuffix(filePath, ".txt") || strings.HasSuffix(filePath, ".log"):
+			return client.readLogs(filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster dump from '%v': %v", dirPath, err)
+	}
+
+	client.addMissingNamespaces()
+	client.dumpTime = client.latestEventTime()
+	return client, nil
+}
+
+func (client *DumpClient) readObjects(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 'kubectl cluster-info dump' without an output directory concatenates several documents
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var raw json.RawMessage
+		err = decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to deserialize json at '%v': %v", filePath, err)
+		}
+
+		var document dumpDocument
+		err = json.Unmarshal(raw, &document)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize json at '%v': %v", filePath, err)
+		}
+		if !strings.HasSuffix(document.Kind, "List") {
+			err = client.addObject(document.TypeMeta, raw)
+			if err != nil {
+				return fmt.Errorf("failed to read %v at '%v': %v", document.Kind, filePath, err)
+			}
+			continue
+		}
+		for _, item := range document.Items {
+			var itemType metaV1.TypeMeta
+			err = json.Unmarshal(item, &itemType)
+			if err != nil {
+				return fmt.Errorf("failed to deserialize json at '%v': %v", filePath, err)
+			}
+			// items of typed lists, e.g. PodList, have no kind of their own
+			if itemType.Kind == "" {
+				itemType.Kind = strings.TrimSuffix(document.Kind, "List")
+				itemType.APIVersion = document.APIVersion
+			}
+			err = client.addObject(itemType, item)
+			if err != nil {
+				return fmt.Errorf("failed to read %v at '%v': %v", itemType.Kind, filePath, err)
+			}
+		}
+	}
+}
+
+func (client *DumpClient) addObject(typeMeta metaV1.TypeMeta, raw json.RawMessage) error {
+	switch typeMeta.Kind {
+	case "Node":
+		var node v1.Node
+		if err := json.Unmarshal(raw, &node); err != nil {
+			return err
+		}
+		client.nodes.Items = append(client.nodes.Items, node)
+	case "Namespace":
+		var namespace v1.Namespace
+		if err := json.Unmarshal(raw, &namespace); err != nil {
+			return err
+		}
+		client.namespaces.Items = append(client.namespaces.Items, namespace)
+	case "Pod":
+		var pod v1.Pod
+		if err := json.Unmarshal(raw, &pod); err != nil {
+			return err
+		}
+		client.pods.Items = append(client.pods.Items, pod)
+	case "ReplicaSet":
+		var replicaSet v12.ReplicaSet
+		if err := json.Unmarshal(raw, &replicaSet); err != nil {
+			return err
+		}
+		client.replicaSets.Items = append(client.replicaSets.Items, replicaSet)
+	case "StatefulSet", "DaemonSet", "Job":
+		var workload metaV1.PartialObjectMetadata
+		if err := json.Unmarshal(raw, &workload); err != nil {
+			return err
+		}
+		client.workloadAnnotations[workloadKey(typeMeta.Kind, workload.Namespace, workload.Name)] = workload.Annotations
+	case "Event":
+		if strings.HasPrefix(typeMeta.APIVersion, eventsV1.GroupName+"/") {
+			var event eventsV1.Event
+			if err := json.Unmarshal(raw, &event); err != nil {
+				return err
+			}
+			client.events.Items = append(client.events.Items, fromEventsV1(&event))
+		} else {
+			var event v1.Event
+			if err := json.Unmarshal(raw, &event); err != nil {
+				return err
+			}
+			client.events.Items = append(client.events.Items, event)
+		}
+	}
+	return nil
+}
+
+// readLogs reads the logs of all containers in a 'logs.txt' file of 'kubectl cluster-info dump'
+func (client *DumpClient) readLogs(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var key string
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := containerLogsStartRegex.FindStringSubmatch(line); match != nil {
+			key = containerKey(match[2], match[3], match[1])
+			lines = nil
+			continue
+		}
+		if match := containerLogsEndRegex.FindStringSubmatch(line); match != nil {
+			if key != "" {
+				client.logsByContainer[key] = strings.Join(lines, "\n")
+			}
+			key = ""
+			continue
+		}
+		if key != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read logs at '%v': %v", filePath, err)
+	}
+	return nil
+}
+
+func containerKey(namespace string, podName string, containerName string) string {
+	return namespace + "/" + podName + "/" + containerName
+}
+
+// addMissingNamespaces adds the namespaces of the dumped objects, as namespaces are not part of a cluster-info dump
+func (client *DumpClient) addMissingNamespaces() {
+	names := map[string]bool{}
+	for _, namespace := range client.namespaces.Items {
+		names[namespace.Name] = true
+	}
+	var missing []string
+	addMissing := func(name string) {
+		if name != "" && !names[name] {
+			names[name] = true
+			missing = append(missing, name)
+		}
+	}
+	for _, pod := range client.pods.Items {
+		addMissing(pod.Namespace)
+	}
+	for _, replicaSet := range client.replicaSets.Items {
+		addMissing(replicaSet.Namespace)
+	}
+	for _, event := range client.events.Items {
+		addMissing(event.Namespace)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		client.namespaces.Items = append(client.namespaces.Items, v1.Namespace{
+			ObjectMeta: metaV1.ObjectMeta{Name: name},
+		})
+	}
+}
+
+func (client *DumpClient) latestEventTime() (latest time.Time) {
+	for _, event := range client.events.Items {
+		lastSeen := eventLastSeen(&event)
+		if lastSeen.After(latest) {
+			latest = lastSeen
+		}
+	}
+	return
+}
+
+// DumpTime estimates when the dump was taken by its latest event, as the diagnosis is relative to that time.
+// It is zero if the dump has no events.
+func (client *DumpClient) DumpTime() time.Time {
+	return client.dumpTime
+}
+
+// GetPodLogs serves the dumped logs within the same bounds as the remote client, so the same excerpts are diagnosed.
+// Lines written before since are dropped if they are prefixed by a timestamp, as the logs of a dump usually are not.
+func (client *DumpClient) GetPodLogs(ctx context.Context, namespace string, podName string, containerName string, since time.Time) (string, error) {
+	logs := client.logsByContainer[containerKey(namespace, podName, containerName)]
+	lines := strings.Split(logs, "\n")
+	if !since.IsZero() {
+		linesSince := lines[:0]
+		for _, line := range lines {
+			timestamp, err := time.Parse(time.RFC3339Nano, strings.SplitN(line, " ", 2)[0])
+			if err != nil || !timestamp.Before(since) {
+				linesSince = append(linesSince, line)
+			}
+		}
+		lines = linesSince
+	}
+	if client.logsTail >= 0 && int64(len(lines)) > client.logsTail {
+		lines = lines[int64(len(lines))-client.logsTail:]
+	}
+	return readTail(strings.NewReader(strings.Join(lines, "\n")), client.logsLimitBytes)
+}
@@ -0,0 +1,150 @@
+package kubeclient
+
+import (
+	"context"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateDumpClient_FromListsDirectory(t *testing.T) {
+	dirPath := "../../test-resources/api-responses/liveness-fails"
+	client, err := CreateDumpClient(&config.Config{DumpDirPath: dirPath, PodLogsTail: 250})
+	require.Nil(t, err)
+
+	mockClient, err := CreateMockClient(
+		nil,
+		"",
+		filepath.Join(dirPath, "ns.json"),
+		filepath.Join(dirPath, "pods.json"),
+		"",
+		filepath.Join(dirPath, "events.json"),
+	)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	namespaces, err := client.GetNamespaces(ctx)
+	require.Nil(t, err)
+	expectedNamespaces, _ := mockClient.GetNamespaces(ctx)
+	assert.Equal(t, len(expectedNamespaces), len(namespaces))
+
+	pods, err := client.GetPods(ctx, "")
+	require.Nil(t, err)
+	expectedPods, _ := mockClient.GetPods(ctx, "")
+	assert.Equal(t, len(expectedPods), len(pods))
+
+	events, err := client.GetEvents(ctx, "", time.Time{})
+	require.Nil(t, err)
+	expectedEvents, _ := mockClient.GetEvents(ctx, "", time.Time{})
+	assert.Equal(t, len(expectedEvents), len(events))
+
+	assert.True(t, asTime("2021-10-31T14:53:44Z").Equal(client.DumpTime()))
+}
+
+const clusterInfoDumpPods = `{
+  "kind": "PodList",
+  "apiVersion": "v1",
+  "items": [
+    {"metadata": {"name": "api-1", "namespace": "app", "labels": {"app": "api"}}, "spec": {"containers": [{"name": "api"}]}},
+    {"metadata": {"name": "coredns-1", "namespace": "kube-system"}, "spec": {"containers": [{"name": "coredns"}]}}
+  ]
+}
+{
+  "kind": "EventList",
+  "apiVersion": "v1",
+  "items": [
+    {"metadata": {"name": "api-1.1", "namespace": "app"}, "type": "Warning", "reason": "BackOff", "lastTimestamp": "2021-10-17T14:00:00Z"}
+  ]
+}`
+
+const clusterInfoDumpLogs = `==== START logs for container api of pod app/api-1 ====
+starting
+line 1
+line 2
+==== END logs for container api of pod app/api-1 ====
+==== START logs for container sidecar of pod app/api-1 ====
+sidecar line
+==== END logs for container sidecar of pod app/api-1 ====
+`
+
+func TestCreateDumpClient_FromClusterInfoDump(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "kubescout-dump-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dirPath)
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dirPath, "all.json"), []byte(clusterInfoDumpPods), 0644))
+	require.Nil(t, os.MkdirAll(filepath.Join(dirPath, "app", "api-1"), 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dirPath, "app", "api-1", "logs.txt"), []byte(clusterInfoDumpLogs), 0644))
+
+	cfg := &config.Config{DumpDirPath: dirPath, PodLogsTail: 2}
+	client, err := CreateDumpClient(cfg)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	namespaces, err := client.GetNamespaces(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(namespaces), "namespaces are derived from the dumped objects")
+	assert.Equal(t, "app", namespaces[0].Name)
+	assert.Equal(t, "kube-system", namespaces[1].Name)
+
+	pods, err := client.GetPods(ctx, "app")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(pods))
+	assert.Equal(t, "api-1", pods[0].Name)
+
+	events, err := client.GetEvents(ctx, "app", time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.True(t, asTime("2021-10-17T14:00:00Z").Equal(client.DumpTime()))
+
+	logs, err := client.GetPodLogs(ctx, "app", "api-1", "api", time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, "line 1\nline 2", logs)
+
+	logs, err = client.GetPodLogs(ctx, "app", "api-1", "sidecar", time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, "sidecar line", logs)
+
+	logs, err = client.GetPodLogs(ctx, "app", "api-1", "missing", time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, "", logs)
+
+	cfg.WorkloadSelector = "app=api"
+	pods, err = client.GetPods(ctx, "")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(pods), "pods are filtered by the workload selector")
+	assert.Equal(t, "api-1", pods[0].Name)
+}
+
+func TestDumpClient_GetPodLogsBounds(t *testing.T) {
+	client := &DumpClient{
+		logsTail: 2,
+		logsByContainer: map[string]string{
+			containerKey("app", "api-1", "api"): "2021-10-17T13:00:00Z old line\n" +
+				"2021-10-17T13:59:00Z recent line\n" +
+				"no timestamp line\n" +
+				"2021-10-17T14:00:00.5Z latest line",
+		},
+	}
+
+	ctx := context.Background()
+	logs, err := client.GetPodLogs(ctx, "app", "api-1", "api", asTime("2021-10-17T13:30:00Z"))
+	require.Nil(t, err)
+	assert.Equal(t, "no timestamp line\n2021-10-17T14:00:00.5Z latest line", logs, "lines before since are dropped, then tailed")
+
+	client.logsTail = -1
+	client.logsLimitBytes = 40
+	logs, err = client.GetPodLogs(ctx, "app", "api-1", "api", asTime("2021-10-17T13:30:00Z"))
+	require.Nil(t, err)
+	assert.Equal(t, "2021-10-17T14:00:00.5Z latest line", logs, "logs are bounded by the bytes limit, from a line start")
+}
+
+func TestCreateDumpClient_MissingDirectory(t *testing.T) {
+	_, err := CreateDumpClient(&config.Config{DumpDirPath: "/non/existing/dump", PodLogsTail: 250})
+	assert.NotNil(t, err)
+}
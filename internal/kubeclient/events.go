@@ -0,0 +1,89 @@
+package kubeclient
+
+import (
+	v1 "k8s.io/api/core/v1"
+	eventsV1 "k8s.io/api/events/v1"
+	"time"
+)
+
+// only non normal events are listed, normal events never result in alerts
+const eventsFieldSelector = "type!=Normal"
+
+func eventLastSeen(event *v1.Event) time.Time {
+	if event.Series != nil && !event.Series.LastObservedTime.IsZero() {
+		return event.Series.LastObservedTime.Time
+	}
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	if !event.FirstTimestamp.IsZero() {
+		return event.FirstTimestamp.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// eventsCollector keeps non normal events seen since a given time, up to a limit per namespace
+type eventsCollector struct {
+	since            time.Time
+	limit            int64
+	countByNamespace map[string]int64
+	events           []v1.Event
+}
+
+func newEventsCollector(since time.Time, limit int64) *eventsCollector {
+	return &eventsCollector{
+		since:            since,
+		limit:            limit,
+		countByNamespace: map[string]int64{},
+		events:           []v1.Event{},
+	}
+}
+
+func (collector *eventsCollector) add(event v1.Event) {
+	if event.Type == v1.EventTypeNormal {
+		return
+	}
+	if !collector.since.IsZero() && eventLastSeen(&event).Before(collector.since) {
+		return
+	}
+	if collector.limit > 0 && collector.countByNamespace[event.Namespace] >= collector.limit {
+		return
+	}
+	collector.countByNamespace[event.Namespace]++
+	collector.events = append(collector.events, event)
+}
+
+// full tells whether no more events of the namespace would be collected
+func (collector *eventsCollector) full(namespace string) bool {
+	return namespace != "" && collector.limit > 0 && collector.countByNamespace[namespace] >= collector.limit
+}
+
+// fromEventsV1 converts an event of the events.k8s.io api to the core event the diagnosis works with
+func fromEventsV1(event *eventsV1.Event) v1.Event {
+	converted := v1.Event{
+		ObjectMeta:          event.ObjectMeta,
+		InvolvedObject:      event.Regarding,
+		Reason:              event.Reason,
+		Message:             event.Note,
+		Source:              event.DeprecatedSource,
+		FirstTimestamp:      event.DeprecatedFirstTimestamp,
+		LastTimestamp:       event.DeprecatedLastTimestamp,
+		Count:               event.DeprecatedCount,
+		Type:                event.Type,
+		EventTime:           event.EventTime,
+		Action:              event.Action,
+		Related:             event.Related,
+		ReportingController: event.ReportingController,
+		ReportingInstance:   event.ReportingInstance,
+	}
+	if event.Series != nil {
+		converted.Series = &v1.EventSeries{
+			Count:            event.Series.Count,
+			LastObservedTime: event.Series.LastObservedTime,
+		}
+	}
+	return converted
+}
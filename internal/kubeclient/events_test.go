@@ -0,0 +1,100 @@
+package kubeclient
+
+import (
+	"context"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	eventsV1 "k8s.io/api/events/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func asTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func eventV1(namespace string, name string, eventType string, eventTime time.Time) *eventsV1.Event {
+	return &eventsV1.Event{
+		ObjectMeta: metaV1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		EventTime: metaV1.NewMicroTime(eventTime),
+		Regarding: v1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: namespace,
+			Name:      "pod",
+		},
+		Reason: "BackOff",
+		Note:   "Back-off restarting failed container",
+		Type:   eventType,
+		Series: &eventsV1.EventSeries{
+			Count:            3,
+			LastObservedTime: metaV1.NewMicroTime(eventTime.Add(time.Minute)),
+		},
+	}
+}
+
+func TestRemoteClient_GetEvents(t *testing.T) {
+	cfg, err := config.DefaultConfig()
+	require.Nil(t, err)
+	cfg.EventsLimit = 2
+
+	lastRun := asTime("2021-10-17T14:00:00Z")
+	clientSet := fake.NewSimpleClientset(
+		eventV1("default", "new-warning", v1.EventTypeWarning, lastRun.Add(time.Minute)),
+		eventV1("default", "old-warning", v1.EventTypeWarning, lastRun.Add(-time.Hour)),
+		eventV1("default", "new-normal", v1.EventTypeNormal, lastRun.Add(time.Minute)),
+		eventV1("default", "another-new-warning", v1.EventTypeWarning, lastRun.Add(time.Minute*2)),
+		eventV1("default", "third-new-warning", v1.EventTypeWarning, lastRun.Add(time.Minute*3)),
+		eventV1("other", "other-new-warning", v1.EventTypeWarning, lastRun.Add(time.Minute)),
+	)
+	client := &remoteKubernetesClient{
+		kubeClientSet: clientSet,
+		config:        cfg,
+	}
+
+	events, err := client.GetEvents(context.Background(), "default", lastRun)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(events), "limited per namespace")
+	for _, event := range events {
+		assert.Equal(t, v1.EventTypeWarning, event.Type)
+		assert.Equal(t, "default", event.Namespace)
+		assert.Equal(t, "Pod", event.InvolvedObject.Kind)
+		assert.Equal(t, "Back-off restarting failed container", event.Message)
+		assert.Equal(t, int32(3), event.Series.Count)
+	}
+
+	events, err = client.GetEvents(context.Background(), "", lastRun)
+	require.Nil(t, err)
+	assert.Equal(t, 3, len(events), "limited per namespace across all namespaces")
+
+	events, err = client.GetEvents(context.Background(), "", time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, 3, len(events))
+}
+
+func TestEventLastSeen(t *testing.T) {
+	created := asTime("2021-10-17T14:00:00Z")
+	event := v1.Event{
+		ObjectMeta: metaV1.ObjectMeta{CreationTimestamp: metaV1.NewTime(created)},
+	}
+	assert.Equal(t, created, eventLastSeen(&event))
+
+	event.FirstTimestamp = metaV1.NewTime(created.Add(time.Minute))
+	assert.Equal(t, created.Add(time.Minute), eventLastSeen(&event))
+
+	event.LastTimestamp = metaV1.NewTime(created.Add(time.Minute * 2))
+	assert.Equal(t, created.Add(time.Minute*2), eventLastSeen(&event))
+
+	event.Series = &v1.EventSeries{LastObservedTime: metaV1.NewMicroTime(created.Add(time.Minute * 3))}
+	assert.Equal(t, created.Add(time.Minute*3), eventLastSeen(&event))
+}
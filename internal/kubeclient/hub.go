@@ -0,0 +1,77 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal/kubeconfig"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+)
+
+// HubCluster is a target cluster whose credentials are kept in a secret of the hub cluster
+type HubCluster struct {
+	Name       string
+	Kubeconfig kubeconfig.KubeConfig
+}
+
+// GetHubClusters lists the secrets of target clusters in the hub cluster, which is the given kubeconfig current context,
+// or the cluster kubescout runs in. Secrets which are not valid cluster credentials are skipped, and their errors returned
+// along with the clusters of the valid ones.
+func GetHubClusters(ctx context.Context, cfg *config.Config, hubKubeconfig kubeconfig.KubeConfig) ([]HubCluster, error) {
+	clientSet, err := buildClientSet(cfg, hubKubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return getHubClusters(ctx, clientSet, cfg.HubSecretsNamespace, cfg.HubSecretsSelector, cfg.HubExecCommands)
+}
+
+func getHubClusters(ctx context.Context, clientSet kubernetes.Interface, namespace string, selector string, execCommands []string) ([]HubCluster, error) {
+	var secrets []v1.Secret
+	err := pagedGet(
+		&metaV1.ListOptions{Limit: pageSize, LabelSelector: selector},
+		func(options metaV1.ListOptions) (runtime.Object, error) {
+			newSecrets, err := clientSet.CoreV1().Secrets(namespace).List(ctx, options)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list cluster secrets in namespace '%v': %w", namespace, err)
+			}
+			secrets = append(secrets, newSecrets.Items...)
+			return newSecrets, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []HubCluster
+	var aggregatedErr error
+	names := map[string]bool{}
+	for i := range secrets {
+		secret := &secrets[i]
+		name, clusterKubeconfig, err := kubeconfig.ClusterFromSecret(secret, execCommands)
+		if err != nil {
+			aggregatedErr = multierr.Append(aggregatedErr, err)
+			continue
+		}
+		if clusterKubeconfig == nil {
+			log.Debugf("Skipping secret %v/%v of the hub cluster itself", secret.Namespace, secret.Name)
+			continue
+		}
+		if names[name] {
+			aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("secret %v/%v is of cluster %v, which is already defined by another secret", secret.Namespace, secret.Name, name))
+			continue
+		}
+		names[name] = true
+		clusters = append(clusters, HubCluster{Name: name, Kubeconfig: clusterKubeconfig})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+	log.Infof("Found %v clusters in %v secrets of the hub cluster", len(clusters), len(secrets))
+	return clusters, aggregatedErr
+}
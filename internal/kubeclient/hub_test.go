@@ -0,0 +1,55 @@
+package kubeclient
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func argoCDSecret(name string, server string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      "cluster-" + name,
+			Namespace: "argocd",
+			Labels:    map[string]string{"argocd.argoproj.io/secret-type": "cluster"},
+		},
+		Data: map[string][]byte{
+			"name":   []byte(name),
+			"server": []byte(server),
+			// language=json
+			"config": []byte(`{"bearerToken": "token"}`),
+		},
+	}
+}
+
+func TestGetHubClusters(t *testing.T) {
+	unlabeled := argoCDSecret("other", "https://other.example.com")
+	unlabeled.Labels = nil
+	invalid := argoCDSecret("invalid", "")
+	clientSet := fake.NewSimpleClientset(
+		argoCDSecret("prod-b", "https://prod-b.example.com"),
+		argoCDSecret("prod-a", "https://prod-a.example.com"),
+		argoCDSecret("in-cluster", "https://kubernetes.default.svc"),
+		unlabeled,
+		invalid,
+	)
+
+	clusters, err := getHubClusters(context.Background(), clientSet, "argocd", "argocd.argoproj.io/secret-type=cluster", nil)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "argocd/cluster-invalid")
+
+	var names []string
+	for _, cluster := range clusters {
+		names = append(names, cluster.Name)
+		assert.Equal(t, cluster.Name, cluster.Kubeconfig.CurrentContext)
+	}
+	assert.Equal(t, []string{"prod-a", "prod-b"}, names)
+
+	clusters, err = getHubClusters(context.Background(), clientSet, "other-namespace", "argocd.argoproj.io/secret-type=cluster", nil)
+	require.Nil(t, err)
+	assert.Empty(t, clusters)
+}
@@ -0,0 +1,374 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal/kubeconfig"
+	log "github.com/sirupsen/logrus"
+	v12 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appsListersV1 "k8s.io/client-go/listers/apps/v1"
+	listersV1 "k8s.io/client-go/listers/core/v1"
+	eventsListersV1 "k8s.io/client-go/listers/events/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"sync"
+	"time"
+)
+
+// deleted pods that were not yet served are dropped after this period
+const deletedPodsRetention = time.Hour
+
+// InformerClient serves the KubernetesClient interface from shared informer caches, which are kept up to date by watches.
+// Pods deleted since the last listing are still served once, so their final state is diagnosed as well.
+type InformerClient struct {
+	remote         *remoteKubernetesClient
+	watchClientSet kubernetes.Interface
+	// nil if not permitted to watch them
+	nodes      listersV1.NodeLister
+	namespaces listersV1.NamespaceLister
+	// workloads and events are watched across all namespaces, or in each included namespace if not permitted to
+	scopes          []*watchScope
+	factories       []informers.SharedInformerFactory
+	informers       []cache.SharedIndexInformer
+	deletedPodsLock sync.Mutex
+	deletedPods     map[string]deletedPod
+}
+
+// watchScope serves the workloads and events of a namespace, or of all namespaces if the namespace is empty
+type watchScope struct {
+	namespace   string
+	pods        listersV1.PodLister
+	replicaSets appsListersV1.ReplicaSetLister
+	// events of either api are watched, as selected by the served apis
+	events   listersV1.EventLister
+	eventsV1 eventsListersV1.EventLister
+}
+
+type deletedPod struct {
+	pod       v1.Pod
+	deletedAt time.Time
+}
+
+var _ KubernetesClient = &InformerClient{}
+
+func CreateInformerClient(config *config.Config, kubeconfig kubeconfig.KubeConfig) (*InformerClient, error) {
+	kconf, err := buildRestConfig(config, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientSet, err := newClientSet(kconf)
+	if err != nil {
+		return nil, err
+	}
+	// watches are long-lived requests, which the per request timeout would cut off
+	watchKconf := rest.CopyConfig(kconf)
+	watchKconf.Timeout = 0
+	watchClientSet, err := newClientSet(watchKconf)
+	if err != nil {
+		return nil, err
+	}
+	return newInformerClient(clientSet, watchClientSet, config), nil
+}
+
+// newInformerClient watches with watchClientSet, while the requests which are not served from cache go through clientSet
+func newInformerClient(clientSet kubernetes.Interface, watchClientSet kubernetes.Interface, config *config.Config) *InformerClient {
+	return &InformerClient{
+		remote: &remoteKubernetesClient{
+			kubeClientSet: clientSet,
+			config:        config,
+		},
+		watchClientSet: watchClientSet,
+		deletedPods:    map[string]deletedPod{},
+	}
+}
+
+// permitted reviews whether all the permissions are granted across all namespaces.
+// The permissions are assumed to be granted if they can not be reviewed, so the watches report why they fail.
+func (client *InformerClient) permitted(ctx context.Context, permissions ...Permission) bool {
+	checks, err := checkPermissions(ctx, client.remote.kubeClientSet, permissions, nil)
+	if err != nil {
+		log.Debugf("Failed to review permissions, assuming they are granted: %v", err)
+		return true
+	}
+	for _, check := range checks {
+		if !check.Allowed {
+			log.Debugf("Not permitted to %v across all namespaces: %v", check.Permission, check.Reason)
+			return false
+		}
+	}
+	return true
+}
+
+func watchPermissions(group string, resource string) []Permission {
+	return []Permission{
+		{Group: group, Resource: resource, Verb: "list"},
+		{Group: group, Resource: resource, Verb: "watch"},
+	}
+}
+
+// Start the watches until ctx is done, without waiting for the caches to sync.
+// Kinds which are not permitted to be watched across all namespaces fall back like the remote client does,
+// nodes are not served and workloads and events are watched in each of the namespaces included by name.
+// onChange is called on every change of a watched object.
+func (client *InformerClient) Start(ctx context.Context, onChange func()) error {
+	config := client.remote.config
+
+	// the events.k8s.io api is watched if served, like GetEvents of the remote client lists it
+	capabilities, err := client.remote.GetCapabilities(ctx)
+	if err != nil {
+		log.Debugf("Served apis are unknown, watching the core events api: %v", err)
+	}
+	eventsV1Served := err == nil && capabilities.Serves("events.k8s.io/v1")
+	eventsGroup := ""
+	if eventsV1Served {
+		eventsGroup = "events.k8s.io"
+	}
+
+	factory := informers.NewSharedInformerFactory(client.watchClientSet, 0)
+	client.factories = append(client.factories, factory)
+	if client.permitted(ctx, watchPermissions("", "nodes")...) {
+		client.nodes = factory.Core().V1().Nodes().Lister()
+		client.informers = append(client.informers, factory.Core().V1().Nodes().Informer())
+	} else {
+		log.Warnf("Not permitted to watch nodes, skipping nodes diagnosis")
+	}
+	if client.permitted(ctx, watchPermissions("", "namespaces")...) {
+		client.namespaces = factory.Core().V1().Namespaces().Lister()
+		client.informers = append(client.informers, factory.Core().V1().Namespaces().Informer())
+	}
+
+	namespaces := []string{metaV1.NamespaceAll}
+	var scopedPermissions []Permission
+	scopedPermissions = append(scopedPermissions, watchPermissions("", "pods")...)
+	scopedPermissions = append(scopedPermissions, watchPermissions("apps", "replicasets")...)
+	scopedPermissions = append(scopedPermissions, watchPermissions(eventsGroup, "events")...)
+	if !client.permitted(ctx, scopedPermissions...) {
+		namespaces = config.IncludeNamespaces.ExactNames()
+		if len(namespaces) == 0 {
+			return fmt.Errorf("not permitted to watch pods, replica sets and events across all namespaces, set the namespaces to watch explicitly with include-ns")
+		}
+		log.Warnf("Not permitted to watch across all namespaces, watching only the included namespaces %v", namespaces)
+	}
+
+	for _, namespace := range namespaces {
+		// workloads are watched with the workload selector, so objects of other teams are never cached
+		workloadFactory := informers.NewSharedInformerFactoryWithOptions(client.watchClientSet, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metaV1.ListOptions) {
+				options.LabelSelector = config.WorkloadSelector
+			}),
+		)
+		// normal events are not watched, as they never result in alerts and are the bulk of the events
+		eventsFactory := informers.NewSharedInformerFactoryWithOptions(client.watchClientSet, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metaV1.ListOptions) {
+				options.FieldSelector = eventsFieldSelector
+			}),
+		)
+		scope := &watchScope{
+			namespace:   namespace,
+			pods:        workloadFactory.Core().V1().Pods().Lister(),
+			replicaSets: workloadFactory.Apps().V1().ReplicaSets().Lister(),
+		}
+		client.informers = append(client.informers,
+			workloadFactory.Core().V1().Pods().Informer(),
+			workloadFactory.Apps().V1().ReplicaSets().Informer(),
+		)
+		if eventsV1Served {
+			scope.eventsV1 = eventsFactory.Events().V1().Events().Lister()
+			client.informers = append(client.informers, eventsFactory.Events().V1().Events().Informer())
+		} else {
+			scope.events = eventsFactory.Core().V1().Events().Lister()
+			client.informers = append(client.informers, eventsFactory.Core().V1().Events().Informer())
+		}
+		client.scopes = append(client.scopes, scope)
+		client.factories = append(client.factories, workloadFactory, eventsFactory)
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			onChange()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			onChange()
+		},
+		DeleteFunc: func(obj interface{}) {
+			client.onDelete(obj)
+			onChange()
+		},
+	}
+	for _, informer := range client.informers {
+		informer.AddEventHandler(handler)
+	}
+
+	for _, factory := range client.factories {
+		factory.Start(ctx.Done())
+	}
+	return nil
+}
+
+// WaitForSync blocks until the caches of the started watches are synced, or fails once ctx is done
+func (client *InformerClient) WaitForSync(ctx context.Context) error {
+	for _, factory := range client.factories {
+		for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return fmt.Errorf("failed to sync informer cache of %v", informerType)
+			}
+		}
+	}
+	return nil
+}
+
+// scopesOf the namespace, or all scopes if the namespace is empty
+func (client *InformerClient) scopesOf(namespace string) []*watchScope {
+	var scopes []*watchScope
+	for _, scope := range client.scopes {
+		if namespace == "" || scope.namespace == "" || scope.namespace == namespace {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func notWatched(resource string) error {
+	return apiErrors.NewForbidden(schema.GroupResource{Resource: resource}, "", fmt.Errorf("not permitted to watch %v", resource))
+}
+
+func (client *InformerClient) onDelete(obj interface{}) {
+	if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+		obj = tombstone.Obj
+	}
+	pod, isPod := obj.(*v1.Pod)
+	if !isPod {
+		return
+	}
+
+	now := time.Now()
+	client.deletedPodsLock.Lock()
+	defer client.deletedPodsLock.Unlock()
+	for key, deleted := range client.deletedPods {
+		if now.Sub(deleted.deletedAt) > deletedPodsRetention {
+			delete(client.deletedPods, key)
+		}
+	}
+	log.Debugf("Pod %v/%v was deleted, keeping its final state for the next diagnosis", pod.Namespace, pod.Name)
+	client.deletedPods[pod.Namespace+"/"+pod.Name] = deletedPod{
+		pod:       *pod.DeepCopy(),
+		deletedAt: now,
+	}
+}
+
+func (client *InformerClient) GetNodes(ctx context.Context) ([]v1.Node, error) {
+	if client.nodes == nil {
+		return nil, notWatched("nodes")
+	}
+	list, err := client.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	nodes := make([]v1.Node, 0, len(list))
+	for _, node := range list {
+		nodes = append(nodes, *node)
+	}
+	return nodes, nil
+}
+
+func (client *InformerClient) GetNamespaces(ctx context.Context) ([]v1.Namespace, error) {
+	if client.namespaces == nil {
+		return nil, notWatched("namespaces")
+	}
+	list, err := client.namespaces.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %v", err)
+	}
+	namespaces := make([]v1.Namespace, 0, len(list))
+	for _, namespace := range list {
+		namespaces = append(namespaces, *namespace)
+	}
+	return namespaces, nil
+}
+
+func (client *InformerClient) GetPods(ctx context.Context, namespace string) ([]v1.Pod, error) {
+	pods := []v1.Pod{}
+	for _, scope := range client.scopesOf(namespace) {
+		list, err := scope.pods.Pods(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods in namespace '%v': %v", namespace, err)
+		}
+		for _, pod := range list {
+			pods = append(pods, *pod)
+		}
+	}
+
+	client.deletedPodsLock.Lock()
+	defer client.deletedPodsLock.Unlock()
+	for key, deleted := range client.deletedPods {
+		if namespace == "" || deleted.pod.Namespace == namespace {
+			pods = append(pods, deleted.pod)
+			delete(client.deletedPods, key)
+		}
+	}
+	return pods, nil
+}
+
+func (client *InformerClient) GetReplicaSets(ctx context.Context, namespace string) ([]v12.ReplicaSet, error) {
+	replicaSets := []v12.ReplicaSet{}
+	for _, scope := range client.scopesOf(namespace) {
+		list, err := scope.replicaSets.ReplicaSets(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list replicaSets for namespace '%v': %v", namespace, err)
+		}
+		for _, replicaSet := range list {
+			replicaSets = append(replicaSets, *replicaSet)
+		}
+	}
+	return replicaSets, nil
+}
+
+func (client *InformerClient) GetEvents(ctx context.Context, namespace string, since time.Time) ([]v1.Event, error) {
+	collector := newEventsCollector(since, client.remote.config.EventsLimit)
+	for _, scope := range client.scopesOf(namespace) {
+		if scope.eventsV1 != nil {
+			list, err := scope.eventsV1.Events(namespace).List(labels.Everything())
+			if err != nil {
+				return nil, fmt.Errorf("failed to get events for %v: %v", namespace, err)
+			}
+			for _, event := range list {
+				collector.add(fromEventsV1(event))
+			}
+			continue
+		}
+		list, err := scope.events.Events(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get events for %v: %v", namespace, err)
+		}
+		for _, event := range list {
+			collector.add(*event)
+		}
+	}
+	return collector.events, nil
+}
+
+// GetCapabilities is not served from cache, the discovery api is not watched
+func (client *InformerClient) GetCapabilities(ctx context.Context) (*alert.ClusterCapabilities, error) {
+	return client.remote.GetCapabilities(ctx)
+}
+
+// GetWorkloadAnnotations is not served from cache, as only the kinds that are listed are watched
+func (client *InformerClient) GetWorkloadAnnotations(ctx context.Context, namespace string, kind string, name string) (map[string]string, error) {
+	return client.remote.GetWorkloadAnnotations(ctx, namespace, kind, name)
+}
+
+// GetPodLogs is not served from cache, logs are always fetched from the api server
+func (client *InformerClient) GetPodLogs(ctx context.Context, namespace string, podName string, containerName string, since time.Time) (string, error) {
+	return client.remote.GetPodLogs(ctx, namespace, podName, containerName, since)
+}
@@ -0,0 +1,167 @@
+package kubeclient
+
+import (
+	"context"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationV1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func pod(namespace string, name string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metaV1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+	}
+}
+
+// permitAll grants every reviewed permission, the fake clientset denies them by default
+func permitAll(clientSet *fake.Clientset) {
+	clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8sTesting.CreateAction).GetObject().(*authorizationV1.SelfSubjectAccessReview)
+		review.Status.Allowed = true
+		return true, review, nil
+	})
+}
+
+func TestInformerClient(t *testing.T) {
+	cfg, err := config.DefaultConfig()
+	require.Nil(t, err)
+	cfg.WorkloadSelector = "team=checkout"
+
+	clientSet := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metaV1.ObjectMeta{Name: "default"}},
+		pod("default", "checkout-api", map[string]string{"team": "checkout"}),
+		pod("default", "payments-api", map[string]string{"team": "payments"}),
+	)
+	permitAll(clientSet)
+	client := newInformerClient(clientSet, clientSet, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var changes int32
+	err = client.Start(ctx, func() {
+		atomic.AddInt32(&changes, 1)
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.WaitForSync(ctx))
+
+	namespaces, err := client.GetNamespaces(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(namespaces))
+
+	pods, err := client.GetPods(context.Background(), "default")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(pods))
+	assert.Equal(t, "checkout-api", pods[0].Name)
+
+	err = clientSet.CoreV1().Pods("default").Delete(context.Background(), "checkout-api", metaV1.DeleteOptions{})
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		client.deletedPodsLock.Lock()
+		defer client.deletedPodsLock.Unlock()
+		return len(client.deletedPods) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	pods, err = client.GetPods(context.Background(), "default")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(pods), "deleted pod is served once")
+	assert.Equal(t, "checkout-api", pods[0].Name)
+
+	pods, err = client.GetPods(context.Background(), "default")
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(pods))
+
+	assert.True(t, atomic.LoadInt32(&changes) >= 3)
+}
+
+func TestInformerClient_Events(t *testing.T) {
+	cfg, err := config.DefaultConfig()
+	require.Nil(t, err)
+
+	now := time.Now()
+	clientSet := fake.NewSimpleClientset(
+		eventV1("default", "warning", v1.EventTypeWarning, now),
+		&v1.Event{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "core-warning"}, Type: v1.EventTypeWarning},
+	)
+	clientSet.Resources = []*metaV1.APIResourceList{
+		{GroupVersion: "v1"},
+		{GroupVersion: "events.k8s.io/v1"},
+	}
+	var fieldSelectors []string
+	clientSet.PrependReactor("list", "events", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		fieldSelectors = append(fieldSelectors, action.(k8sTesting.ListAction).GetListRestrictions().Fields.String())
+		return false, nil, nil
+	})
+	permitAll(clientSet)
+	client := newInformerClient(clientSet, clientSet, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = client.Start(ctx, func() {})
+	require.Nil(t, err)
+	require.Nil(t, client.WaitForSync(ctx))
+
+	assert.Equal(t, []string{"type!=Normal"}, fieldSelectors, "only the events.k8s.io api is watched, without normal events")
+	events, err := client.GetEvents(ctx, "default", time.Time{})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(events))
+	assert.Equal(t, "warning", events[0].Name)
+	assert.Equal(t, "Back-off restarting failed container", events[0].Message)
+}
+
+func TestInformerClient_NamespaceScoped(t *testing.T) {
+	cfg, err := config.DefaultConfig()
+	require.Nil(t, err)
+	cfg.IncludeNamespaces, err = internal.CompileNamePatterns([]string{"app"})
+	require.Nil(t, err)
+
+	clientSet := fake.NewSimpleClientset(
+		pod("app", "api", nil),
+		pod("other", "api", nil),
+	)
+	// mimics a role bound in the app namespace only
+	clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8sTesting.CreateAction).GetObject().(*authorizationV1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Namespace == "app"
+		return true, review, nil
+	})
+	var listedNamespaces []string
+	clientSet.PrependReactor("list", "*", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		listedNamespaces = append(listedNamespaces, action.GetNamespace())
+		return false, nil, nil
+	})
+	client := newInformerClient(clientSet, clientSet, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Nil(t, client.Start(ctx, func() {}))
+	require.Nil(t, client.WaitForSync(ctx))
+
+	assert.Equal(t, []string{"app", "app", "app"}, listedNamespaces, "only the included namespace is watched")
+	_, err = client.GetNodes(ctx)
+	assert.True(t, IsForbidden(err))
+	_, err = client.GetNamespaces(ctx)
+	assert.True(t, IsForbidden(err), "namespaces fall back to the included names")
+
+	pods, err := client.GetPods(ctx, "")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(pods))
+	assert.Equal(t, "app", pods[0].Namespace)
+
+	cfg.IncludeNamespaces = nil
+	client = newInformerClient(clientSet, clientSet, cfg)
+	assert.NotNil(t, client.Start(ctx, func() {}), "namespaces to watch are unknown")
+}
@@ -0,0 +1,133 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal/kubeconfig"
+	authorizationV1 "k8s.io/api/authorization/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is a verb on a resource that kubescout needs in order to run with the given config
+type Permission struct {
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+	Namespaced  bool
+	// Fallback is what kubescout does when the permission is denied, or empty if the permission is required
+	Fallback string
+}
+
+// ResourcePath is the resource as written in rbac rules, e.g. 'pods/log'
+func (permission Permission) ResourcePath() string {
+	if permission.Subresource != "" {
+		return permission.Resource + "/" + permission.Subresource
+	}
+	return permission.Resource
+}
+
+func (permission Permission) String() string {
+	resource := permission.ResourcePath()
+	if permission.Group != "" {
+		resource += "." + permission.Group
+	}
+	return permission.Verb + " " + resource
+}
+
+// RequiredPermissions of the checks enabled by the config, in the order they are used.
+// Permissions with a fallback are optional, as kubescout warns and carries on without them.
+func RequiredPermissions(cfg *config.Config) []Permission {
+	verbs := []string{"list"}
+	if cfg.Watch {
+		verbs = append(verbs, "watch")
+	}
+
+	nodesFallback := "nodes are not diagnosed"
+	var namespacesFallback string
+	if len(cfg.IncludeNamespaces.ExactNames()) > 0 {
+		namespacesFallback = "only the namespaces listed by name in include-ns are scanned"
+	}
+
+	var permissions []Permission
+	for _, verb := range verbs {
+		permissions = append(permissions,
+			Permission{Resource: "nodes", Verb: verb, Fallback: nodesFallback},
+			Permission{Resource: "namespaces", Verb: verb, Fallback: namespacesFallback},
+			Permission{Resource: "pods", Verb: verb, Namespaced: true},
+			Permission{Group: "apps", Resource: "replicasets", Verb: verb, Namespaced: true},
+			// core events are listed or watched on clusters without the events.k8s.io api
+			Permission{Resource: "events", Verb: verb, Namespaced: true},
+			Permission{Group: "events.k8s.io", Resource: "events", Verb: verb, Namespaced: true},
+		)
+	}
+	// pod controllers which are not listed are fetched for the overrides in their annotations
+	workloadsFallback := "overrides annotated on stateful sets, daemon sets and jobs are not applied"
+	permissions = append(permissions,
+		Permission{Group: "apps", Resource: "statefulsets", Verb: "get", Namespaced: true, Fallback: workloadsFallback},
+		Permission{Group: "apps", Resource: "daemonsets", Verb: "get", Namespaced: true, Fallback: workloadsFallback},
+		Permission{Group: "batch", Resource: "jobs", Verb: "get", Namespaced: true, Fallback: workloadsFallback},
+	)
+	if cfg.PodLogsTail != 0 {
+		permissions = append(permissions, Permission{Resource: "pods", Subresource: "log", Verb: "get", Namespaced: true})
+	}
+	// installed CRDs are listed along with the served apis
+	permissions = append(permissions, Permission{
+		Group:    "apiextensions.k8s.io",
+		Resource: "customresourcedefinitions",
+		Verb:     "list",
+		Fallback: "installed CRDs are not reported in the cluster capabilities",
+	})
+	return permissions
+}
+
+// PermissionCheck is the result of reviewing a permission in a namespace, or cluster wide if the namespace is empty
+type PermissionCheck struct {
+	Permission
+	Namespace string
+	Allowed   bool
+	Reason    string
+}
+
+// CheckPermissions reviews the required permissions of the current context cluster wide, and in each of the namespaces
+func CheckPermissions(ctx context.Context, cfg *config.Config, kubeconfig kubeconfig.KubeConfig, namespaces []string) ([]PermissionCheck, error) {
+	clientSet, err := buildClientSet(cfg, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return checkPermissions(ctx, clientSet, RequiredPermissions(cfg), namespaces)
+}
+
+func checkPermissions(ctx context.Context, clientSet kubernetes.Interface, permissions []Permission, namespaces []string) ([]PermissionCheck, error) {
+	var checks []PermissionCheck
+	for _, namespace := range append([]string{""}, namespaces...) {
+		for _, permission := range permissions {
+			if namespace != "" && !permission.Namespaced {
+				continue
+			}
+			review, err := clientSet.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationV1.SelfSubjectAccessReview{
+				Spec: authorizationV1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationV1.ResourceAttributes{
+						Namespace:   namespace,
+						Verb:        permission.Verb,
+						Group:       permission.Group,
+						Resource:    permission.Resource,
+						Subresource: permission.Subresource,
+					},
+				},
+			}, metaV1.CreateOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to review permission to %v: %v", permission, err)
+			}
+			checks = append(checks, PermissionCheck{
+				Permission: permission,
+				Namespace:  namespace,
+				Allowed:    review.Status.Allowed,
+				Reason:     review.Status.Reason,
+			})
+		}
+	}
+	return checks, nil
+}
@@ -0,0 +1,151 @@
+package kubeclient
+
+import (
+	"context"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	authorizationV1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+)
+
+func TestRequiredPermissions(t *testing.T) {
+	cfg, err := config.DefaultConfig()
+	require.Nil(t, err)
+
+	var names []string
+	for _, permission := range RequiredPermissions(cfg) {
+		names = append(names, permission.String())
+	}
+	assert.Equal(t, []string{
+		"list nodes",
+		"list namespaces",
+		"list pods",
+		"list replicasets.apps",
+		"list events",
+		"list events.events.k8s.io",
+		"get statefulsets.apps",
+		"get daemonsets.apps",
+		"get jobs.batch",
+		"get pods/log",
+		"list customresourcedefinitions.apiextensions.k8s.io",
+	}, names)
+
+	permissions := RequiredPermissions(cfg)
+	assert.NotEqual(t, "", permissions[0].Fallback, "nodes are skipped when not permitted")
+	assert.Equal(t, "", permissions[1].Fallback, "namespaces are required without names to fall back to")
+	assert.Equal(t, "", permissions[2].Fallback)
+	assert.NotEqual(t, "", permissions[6].Fallback)
+
+	cfg.IncludeNamespaces, err = internal.CompileNamePatterns([]string{"team-a", "team-b-*"})
+	require.Nil(t, err)
+	permissions = RequiredPermissions(cfg)
+	assert.NotEqual(t, "", permissions[1].Fallback, "included namespace names are scanned when namespaces are not permitted")
+
+	cfg.Watch = true
+	cfg.PodLogsTail = 0
+	names = nil
+	for _, permission := range RequiredPermissions(cfg) {
+		names = append(names, permission.String())
+	}
+	assert.Contains(t, names, "watch pods")
+	assert.Contains(t, names, "watch events.events.k8s.io")
+	assert.NotContains(t, names, "get pods/log")
+	assert.NotEqual(t, "", RequiredPermissions(cfg)[0].Fallback, "nodes are not watched when not permitted")
+}
+
+func TestCheckPermissions(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	var reviews []authorizationV1.ResourceAttributes
+	clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8sTesting.CreateAction).GetObject().(*authorizationV1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		reviews = append(reviews, *attributes)
+		// mimics a role bound in a single namespace only
+		review.Status.Allowed = attributes.Namespace == "app"
+		if !review.Status.Allowed {
+			review.Status.Reason = "no role binding"
+		}
+		return true, review, nil
+	})
+
+	permissions := []Permission{
+		{Resource: "nodes", Verb: "list"},
+		{Resource: "pods", Subresource: "log", Verb: "get", Namespaced: true},
+	}
+	checks, err := checkPermissions(context.Background(), clientSet, permissions, []string{"app", "other"})
+	require.Nil(t, err)
+	require.Equal(t, 4, len(checks), "cluster scoped permissions are not reviewed per namespace")
+	assert.Equal(t, 4, len(reviews))
+
+	assert.Equal(t, "", checks[0].Namespace)
+	assert.False(t, checks[0].Allowed)
+	assert.Equal(t, "no role binding", checks[0].Reason)
+	assert.Equal(t, "", checks[1].Namespace)
+	assert.False(t, checks[1].Allowed)
+	assert.Equal(t, "app", checks[2].Namespace)
+	assert.True(t, checks[2].Allowed)
+	assert.Equal(t, "other", checks[3].Namespace)
+	assert.False(t, checks[3].Allowed)
+
+	assert.Equal(t, "log", reviews[1].Subresource)
+	assert.Equal(t, "get", reviews[1].Verb)
+}
+
+// TestRequiredPermissions_Chart checks that the helm chart grants exactly the permissions of a scan and of the watch mode
+func TestRequiredPermissions_Chart(t *testing.T) {
+	content, err := ioutil.ReadFile("../../chart/templates/rbac.yaml")
+	require.Nil(t, err)
+	// the cluster role is the first document, template directives are dropped to parse it as yaml
+	var lines []string
+	for _, line := range strings.Split(strings.Split(string(content), "\n---")[0], "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "{{") {
+			lines = append(lines, line)
+		}
+	}
+	var clusterRole struct {
+		Kind  string `yaml:"kind"`
+		Rules []struct {
+			APIGroups []string `yaml:"apiGroups"`
+			Resources []string `yaml:"resources"`
+			Verbs     []string `yaml:"verbs"`
+		} `yaml:"rules"`
+	}
+	require.Nil(t, yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &clusterRole))
+	require.Equal(t, "ClusterRole", clusterRole.Kind)
+
+	granted := map[string]bool{}
+	for _, rule := range clusterRole.Rules {
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, verb := range rule.Verbs {
+					granted[Permission{Group: group, Resource: resource, Verb: verb}.String()] = true
+				}
+			}
+		}
+	}
+
+	required := map[string]bool{}
+	for _, watch := range []bool{false, true} {
+		cfg, err := config.DefaultConfig()
+		require.Nil(t, err)
+		cfg.Watch = watch
+		for _, permission := range RequiredPermissions(cfg) {
+			required[permission.String()] = true
+		}
+	}
+
+	for permission := range granted {
+		assert.True(t, required[permission], "%v is granted by the chart but not required", permission)
+	}
+	for permission := range required {
+		assert.True(t, granted[permission], "%v is required but not granted by the chart", permission)
+	}
+}
@@ -0,0 +1,363 @@
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/internal/redact"
+	"io/ioutil"
+	v12 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// file names of a recording, the objects files are in the layout of the mock client fixtures
+const (
+	recordingNodesFileName      = "nodes.json"
+	recordingNamespacesFileName = "ns.json"
+	recordingPodsFileName       = "pods.json"
+	recordingRsFileName         = "rs.json"
+	recordingEventsFileName     = "events.json"
+	recordingLogsFileName       = "logs.json"
+	recordingWorkloadsFileName  = "workloads.json"
+	recordingMetadataFileName   = "recording.json"
+)
+
+// Recording describes when and where the responses of a recording were captured
+type Recording struct {
+	Cluster string    `json:"cluster"`
+	Now     time.Time `json:"now"`
+	// listings which failed as forbidden, and are replayed as such
+	Forbidden    []ForbiddenListing         `json:"forbidden,omitempty"`
+	Capabilities *alert.ClusterCapabilities `json:"capabilities,omitempty"`
+}
+
+// ForbiddenListing is a listing of a resource in a namespace, or across all namespaces if the namespace is empty
+type ForbiddenListing struct {
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RecordingClient captures every response of the wrapped client, so a diagnosis can be replayed from them
+type RecordingClient struct {
+	client              KubernetesClient
+	redactor            *redact.Redactor
+	lock                sync.Mutex
+	nodes               map[string]v1.Node
+	namespaces          map[string]v1.Namespace
+	pods                map[string]v1.Pod
+	replicaSets         map[string]v12.ReplicaSet
+	events              map[string]v1.Event
+	logsByContainer     map[string]string
+	workloadAnnotations map[string]map[string]string
+	forbidden           map[string]ForbiddenListing
+	capabilities        *alert.ClusterCapabilities
+}
+
+var _ KubernetesClient = &RecordingClient{}
+
+// NewRecordingClient wraps the client, the recorded logs are redacted by the redactor when saved, or kept as is if it is nil
+func NewRecordingClient(client KubernetesClient, redactor *redact.Redactor) *RecordingClient {
+	return &RecordingClient{
+		client:              client,
+		redactor:            redactor,
+		nodes:               map[string]v1.Node{},
+		namespaces:          map[string]v1.Namespace{},
+		pods:                map[string]v1.Pod{},
+		replicaSets:         map[string]v12.ReplicaSet{},
+		events:              map[string]v1.Event{},
+		logsByContainer:     map[string]string{},
+		workloadAnnotations: map[string]map[string]string{},
+		forbidden:           map[string]ForbiddenListing{},
+	}
+}
+
+func objectKey(object metaV1.ObjectMeta) string {
+	return object.Namespace + "/" + object.Name
+}
+
+func (client *RecordingClient) recordFailure(resource string, namespace string, err error) {
+	if err != nil && IsForbidden(err) {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		client.forbidden[forbiddenKey(resource, namespace)] = ForbiddenListing{Resource: resource, Namespace: namespace}
+	}
+}
+
+func (client *RecordingClient) GetNodes(ctx context.Context) ([]v1.Node, error) {
+	nodes, err := client.client.GetNodes(ctx)
+	client.recordFailure("nodes", "", err)
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	for _, node := range nodes {
+		client.nodes[objectKey(node.ObjectMeta)] = node
+	}
+	return nodes, err
+}
+
+func (client *RecordingClient) GetNamespaces(ctx context.Context) ([]v1.Namespace, error) {
+	namespaces, err := client.client.GetNamespaces(ctx)
+	client.recordFailure("namespaces", "", err)
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	for _, namespace := range namespaces {
+		client.namespaces[objectKey(namespace.ObjectMeta)] = namespace
+	}
+	return namespaces, err
+}
+
+func (client *RecordingClient) GetPods(ctx context.Context, namespace string) ([]v1.Pod, error) {
+	pods, err := client.client.GetPods(ctx, namespace)
+	client.recordFailure("pods", namespace, err)
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	for _, pod := range pods {
+		client.pods[objectKey(pod.ObjectMeta)] = pod
+	}
+	return pods, err
+}
+
+func (client *RecordingClient) GetReplicaSets(ctx context.Context, namespace string) ([]v12.ReplicaSet, error) {
+	replicaSets, err := client.client.GetReplicaSets(ctx, namespace)
+	client.recordFailure("replicasets", namespace, err)
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	for _, replicaSet := range replicaSets {
+		client.replicaSets[objectKey(replicaSet.ObjectMeta)] = replicaSet
+	}
+	return replicaSets, err
+}
+
+func (client *RecordingClient) GetPodLogs(ctx context.Context, namespace string, podName string, containerName string, since time.Time) (string, error) {
+	logs, err := client.client.GetPodLogs(ctx, namespace, podName, containerName, since)
+	if err == nil {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		client.logsByContainer[containerKey(namespace, podName, containerName)] = logs
+	}
+	return logs, err
+}
+
+func (client *RecordingClient) GetEvents(ctx context.Context, namespace string, since time.Time) ([]v1.Event, error) {
+	events, err := client.client.GetEvents(ctx, namespace, since)
+	client.recordFailure("events", namespace, err)
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	for _, event := range events {
+		client.events[objectKey(event.ObjectMeta)] = event
+	}
+	return events, err
+}
+
+func (client *RecordingClient) GetCapabilities(ctx context.Context) (*alert.ClusterCapabilities, error) {
+	capabilities, err := client.client.GetCapabilities(ctx)
+	if err == nil {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		client.capabilities = capabilities
+	}
+	return capabilities, err
+}
+
+func (client *RecordingClient) GetWorkloadAnnotations(ctx context.Context, namespace string, kind string, name string) (map[string]string, error) {
+	annotations, err := client.client.GetWorkloadAnnotations(ctx, namespace, kind, name)
+	if err == nil {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		client.workloadAnnotations[workloadKey(kind, namespace, name)] = annotations
+	}
+	return annotations, err
+}
+
+// sortedKeys orders objects by namespace and name, as the api server lists them
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// Save writes the recorded responses to the directory, along with the time the diagnosis ran at.
+// Logs are redacted the same way as the alerts, as recordings are meant to be attached to bug reports.
+func (client *RecordingClient) Save(dirPath string, cluster string, now time.Time) error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	err := os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create recording directory at '%v': %v", dirPath, err)
+	}
+
+	var keys []string
+
+	nodes := &v1.NodeList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}}
+	for key := range client.nodes {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		node := client.nodes[key]
+		node.TypeMeta = metaV1.TypeMeta{Kind: "Node", APIVersion: "v1"}
+		nodes.Items = append(nodes.Items, node)
+	}
+
+	namespaces := &v1.NamespaceList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}}
+	keys = nil
+	for key := range client.namespaces {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		namespace := client.namespaces[key]
+		namespace.TypeMeta = metaV1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}
+		namespaces.Items = append(namespaces.Items, namespace)
+	}
+
+	pods := &v1.PodList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}}
+	keys = nil
+	for key := range client.pods {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		pod := client.pods[key]
+		pod.TypeMeta = metaV1.TypeMeta{Kind: "Pod", APIVersion: "v1"}
+		pods.Items = append(pods.Items, pod)
+	}
+
+	replicaSets := &v12.ReplicaSetList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}}
+	keys = nil
+	for key := range client.replicaSets {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		replicaSet := client.replicaSets[key]
+		replicaSet.TypeMeta = metaV1.TypeMeta{Kind: "ReplicaSet", APIVersion: "apps/v1"}
+		replicaSets.Items = append(replicaSets.Items, replicaSet)
+	}
+
+	events := &v1.EventList{TypeMeta: metaV1.TypeMeta{Kind: "List", APIVersion: "v1"}}
+	keys = nil
+	for key := range client.events {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		event := client.events[key]
+		event.TypeMeta = metaV1.TypeMeta{Kind: "Event", APIVersion: "v1"}
+		events.Items = append(events.Items, event)
+	}
+
+	logsByContainer := client.logsByContainer
+	if client.redactor != nil {
+		logsByContainer = make(map[string]string, len(client.logsByContainer))
+		for key, logs := range client.logsByContainer {
+			logsByContainer[key], _ = client.redactor.Redact(logs)
+		}
+	}
+
+	recording := &Recording{
+		Cluster:      cluster,
+		Now:          now,
+		Capabilities: client.capabilities,
+	}
+	keys = nil
+	for key := range client.forbidden {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		recording.Forbidden = append(recording.Forbidden, client.forbidden[key])
+	}
+
+	for fileName, content := range map[string]interface{}{
+		recordingNodesFileName:      nodes,
+		recordingNamespacesFileName: namespaces,
+		recordingPodsFileName:       pods,
+		recordingRsFileName:         replicaSets,
+		recordingEventsFileName:     events,
+		recordingLogsFileName:       logsByContainer,
+		recordingWorkloadsFileName:  client.workloadAnnotations,
+		recordingMetadataFileName:   recording,
+	} {
+		err = toJson(path.Join(dirPath, fileName), content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toJson(filePath string, content interface{}) error {
+	bytes, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize json for '%v': %v", filePath, err)
+	}
+	err = ioutil.WriteFile(filePath, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write file at '%v': %v", filePath, err)
+	}
+	return nil
+}
+
+// ReplayClient serves the responses of a recording
+type ReplayClient struct {
+	*mockKubernetesClient
+	Recording       Recording
+	logsByContainer map[string]string
+}
+
+var _ KubernetesClient = &ReplayClient{}
+
+// IsRecording tells whether the directory holds a recording of a single cluster
+func IsRecording(dirPath string) bool {
+	return fileRelevant(path.Join(dirPath, recordingMetadataFileName))
+}
+
+func CreateReplayClient(dirPath string) (*ReplayClient, error) {
+	client := &ReplayClient{
+		logsByContainer: map[string]string{},
+	}
+	err := fromJson(path.Join(dirPath, recordingMetadataFileName), &client.Recording)
+	if err != nil {
+		return nil, err
+	}
+
+	// responses were recorded with the workload selector applied, and are replayed as is
+	client.mockKubernetesClient, err = CreateMockClient(
+		nil,
+		path.Join(dirPath, recordingNodesFileName),
+		path.Join(dirPath, recordingNamespacesFileName),
+		path.Join(dirPath, recordingPodsFileName),
+		path.Join(dirPath, recordingRsFileName),
+		path.Join(dirPath, recordingEventsFileName),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, forbidden := range client.Recording.Forbidden {
+		client.Forbid(forbidden.Resource, forbidden.Namespace)
+	}
+	if client.Recording.Capabilities != nil {
+		client.SetCapabilities(client.Recording.Capabilities)
+	}
+
+	logsFilePath := path.Join(dirPath, recordingLogsFileName)
+	if fileRelevant(logsFilePath) {
+		err = fromJson(logsFilePath, &client.logsByContainer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	workloadsFilePath := path.Join(dirPath, recordingWorkloadsFileName)
+	if fileRelevant(workloadsFilePath) {
+		err = fromJson(workloadsFilePath, &client.workloadAnnotations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (client *ReplayClient) GetPodLogs(ctx context.Context, namespace string, podName string, containerName string, since time.Time) (string, error) {
+	return client.logsByContainer[containerKey(namespace, podName, containerName)], nil
+}
@@ -0,0 +1,133 @@
+package kubeclient
+
+import (
+	"context"
+	"github.com/reallyliri/kubescout/internal/redact"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestRecordingClient_SaveAndReplay(t *testing.T) {
+	dirPath := path.Join(apiResponsesDirectoryPath, "liveness-fails")
+	client, err := CreateMockClient(
+		nil,
+		"",
+		path.Join(dirPath, "ns.json"),
+		path.Join(dirPath, "pods.json"),
+		"",
+		path.Join(dirPath, "events.json"),
+	)
+	require.Nil(t, err)
+	client.ForbidClusterScope()
+
+	ctx := context.Background()
+	recorder := NewRecordingClient(client, nil)
+	_, err = recorder.GetNamespaces(ctx)
+	require.NotNil(t, err)
+	pods, err := recorder.GetPods(ctx, "dd9bf8cf4edf444589e69aaa05")
+	require.Nil(t, err)
+	require.NotEmpty(t, pods)
+	logs, err := recorder.GetPodLogs(ctx, pods[0].Namespace, pods[0].Name, "api", time.Time{})
+	require.Nil(t, err)
+
+	now := asTime("2021-10-31T15:00:00Z")
+	recordingDirPath := path.Join(t.TempDir(), "cluster")
+	require.Nil(t, recorder.Save(recordingDirPath, "arn:cluster/name", now))
+	require.True(t, IsRecording(recordingDirPath))
+
+	replayClient, err := CreateReplayClient(recordingDirPath)
+	require.Nil(t, err)
+	assert.Equal(t, "arn:cluster/name", replayClient.Recording.Cluster)
+	assert.True(t, now.Equal(replayClient.Recording.Now))
+
+	_, err = replayClient.GetNamespaces(ctx)
+	assert.True(t, IsForbidden(err), "forbidden cluster scope is replayed")
+
+	replayedPods, err := replayClient.GetPods(ctx, "dd9bf8cf4edf444589e69aaa05")
+	require.Nil(t, err)
+	assert.Equal(t, len(pods), len(replayedPods))
+
+	replayedLogs, err := replayClient.GetPodLogs(ctx, pods[0].Namespace, pods[0].Name, "api", time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, logs, replayedLogs)
+
+	events, err := replayClient.GetEvents(ctx, "dd9bf8cf4edf444589e69aaa05", time.Time{})
+	require.Nil(t, err)
+	assert.Empty(t, events, "events that were not listed are not recorded")
+}
+
+func TestRecordingClient_ReplaysForbiddenListings(t *testing.T) {
+	dirPath := path.Join(apiResponsesDirectoryPath, "liveness-fails")
+	client, err := CreateMockClient(
+		nil,
+		"",
+		path.Join(dirPath, "ns.json"),
+		path.Join(dirPath, "pods.json"),
+		"",
+		path.Join(dirPath, "events.json"),
+	)
+	require.Nil(t, err)
+	client.Forbid("nodes", "")
+
+	ctx := context.Background()
+	recorder := NewRecordingClient(client, nil)
+	_, err = recorder.GetNodes(ctx)
+	require.True(t, IsForbidden(err))
+	_, err = recorder.GetNamespaces(ctx)
+	require.Nil(t, err)
+	pods, err := recorder.GetPods(ctx, "")
+	require.Nil(t, err)
+	require.NotEmpty(t, pods)
+
+	recordingDirPath := path.Join(t.TempDir(), "cluster")
+	require.Nil(t, recorder.Save(recordingDirPath, "cluster", asTime("2021-10-31T15:00:00Z")))
+	replayClient, err := CreateReplayClient(recordingDirPath)
+	require.Nil(t, err)
+	assert.Equal(t, []ForbiddenListing{{Resource: "nodes"}}, replayClient.Recording.Forbidden)
+
+	_, err = replayClient.GetNodes(ctx)
+	assert.True(t, IsForbidden(err), "forbidden listings are replayed")
+	_, err = replayClient.GetNamespaces(ctx)
+	assert.Nil(t, err, "only the forbidden listings fail")
+	replayedPods, err := replayClient.GetPods(ctx, "")
+	require.Nil(t, err)
+	assert.Equal(t, len(pods), len(replayedPods))
+}
+
+type logsClient struct {
+	*mockKubernetesClient
+	logs string
+}
+
+func (client *logsClient) GetPodLogs(ctx context.Context, namespace string, podName string, containerName string, since time.Time) (string, error) {
+	return client.logs, nil
+}
+
+func TestRecordingClient_SaveRedactsLogs(t *testing.T) {
+	mockClient, err := CreateMockClient(nil, "", "", "", "", "")
+	require.Nil(t, err)
+	client := &logsClient{
+		mockKubernetesClient: mockClient,
+		logs:                 "connecting to postgres://admin:hunter2@db:5432\nconnected",
+	}
+	redactor, err := redact.NewRedactor(nil)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	recorder := NewRecordingClient(client, redactor)
+	logs, err := recorder.GetPodLogs(ctx, "app", "api-1", "api", time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, client.logs, logs, "the diagnosis gets the logs as is")
+
+	recordingDirPath := path.Join(t.TempDir(), "cluster")
+	require.Nil(t, recorder.Save(recordingDirPath, "cluster", asTime("2021-10-31T15:00:00Z")))
+
+	replayClient, err := CreateReplayClient(recordingDirPath)
+	require.Nil(t, err)
+	replayedLogs, err := replayClient.GetPodLogs(ctx, "app", "api-1", "api", time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, "connecting to postgres://admin:[REDACTED]@db:5432\nconnected", replayedLogs)
+}
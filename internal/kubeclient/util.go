@@ -1,6 +1,8 @@
 package kubeclient
 
 import (
+	"errors"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -10,6 +12,9 @@ const pageSize = 32
 
 var metadataAccessor = meta.NewAccessor()
 
+// errStopPaging is returned by a list function to stop paging without failing
+var errStopPaging = errors.New("stop paging")
+
 func pagedGet(
 	initialOpts *metaV1.ListOptions,
 	listFunc func(metaV1.ListOptions) (runtime.Object, error),
@@ -22,6 +27,9 @@ func pagedGet(
 	}
 	for {
 		list, err := listFunc(*opts)
+		if errors.Is(err, errStopPaging) {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -32,3 +40,8 @@ func pagedGet(
 		opts.Continue = nextContinueToken
 	}
 }
+
+// IsForbidden tells whether the error is due to the client not being permitted to perform the request
+func IsForbidden(err error) bool {
+	return apiErrors.IsForbidden(err)
+}
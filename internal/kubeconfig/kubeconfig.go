@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 )
 
 const serviceAccountTokenInClusterPath = "/var/run/secrets/kubernetes.io/serviceaccount"
@@ -35,10 +36,23 @@ func DefaultKubeconfigPath(forceNotInCluster bool) (filePath string, runningInCl
 	return filepath.Join(homedirPath, ".kube", "config"), false, nil
 }
 
+// LoadKubeconfig loads and merges kubeconfig files like kubectl does, where the path may be a list separated by the os
+// path list separator (e.g. '~/.kube/a:~/.kube/b') and a directory stands for all the files in it.
+// Of entries defined in multiple files, the first file wins.
 func LoadKubeconfig(configFilePath string) (KubeConfig, error) {
 	log.Infof("Using kubeconfig from '%v'", configFilePath)
 
-	kubeconfig, err := clientcmd.LoadFromFile(configFilePath)
+	filePaths, err := kubeconfigFilePaths(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(filePaths) == 0 {
+		return nil, fmt.Errorf("failed to load kubeconfig from '%v': no kubeconfig files found", configFilePath)
+	}
+	log.Debugf("Merging kubeconfig files %v", filePaths)
+
+	loadingRules := &clientcmd.ClientConfigLoadingRules{Precedence: filePaths}
+	kubeconfig, err := loadingRules.Load()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load kubeconfig from '%v': %v", configFilePath, err)
 	}
@@ -46,39 +60,127 @@ func LoadKubeconfig(configFilePath string) (KubeConfig, error) {
 	return kubeconfig, nil
 }
 
+// kubeconfigFilePaths expands a list of kubeconfig paths to the existing files, in order, skipping missing paths as kubectl does
+func kubeconfigFilePaths(configFilePath string) ([]string, error) {
+	var filePaths []string
+	for _, entry := range filepath.SplitList(configFilePath) {
+		if entry == "" {
+			continue
+		}
+		if strings.HasPrefix(entry, "~"+string(filepath.Separator)) {
+			entry = filepath.Join(homedir.HomeDir(), entry[2:])
+		}
+
+		info, err := os.Stat(entry)
+		if os.IsNotExist(err) {
+			log.Debugf("Skipping kubeconfig '%v' which does not exist", entry)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubeconfig at '%v': %v", entry, err)
+		}
+		if !info.IsDir() {
+			filePaths = append(filePaths, entry)
+			continue
+		}
+
+		dirEntries, err := os.ReadDir(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubeconfig directory at '%v': %v", entry, err)
+		}
+		for _, dirEntry := range dirEntries {
+			if dirEntry.IsDir() || strings.HasPrefix(dirEntry.Name(), ".") {
+				continue
+			}
+			filePaths = append(filePaths, filepath.Join(entry, dirEntry.Name()))
+		}
+	}
+	return filePaths, nil
+}
+
+// WithCurrentContext returns a copy of the kubeconfig set to the given context, so contexts can be used concurrently
+func WithCurrentContext(kubeconfig KubeConfig, contextName string) KubeConfig {
+	if kubeconfig == nil {
+		return nil
+	}
+	copied := (*clientcmdapi.Config)(kubeconfig).DeepCopy()
+	copied.CurrentContext = contextName
+	return copied
+}
+
+// ContextNames resolves the contexts to scan, where the selected and excluded names may be exact names,
+// globs (e.g. 'prod-*') or regexes wrapped in slashes (e.g. '/^prod-\d+$/').
+// A pattern which matches no context is an error, so a typo does not silently scan no clusters.
 func ContextNames(
 	kubeconfig KubeConfig,
-	selectedName string,
+	selectedNames []string,
 	allContexts bool,
 	excludedNames []string,
 ) ([]string, error) {
-	if !allContexts && selectedName == "" {
+	if !allContexts && len(selectedNames) == 0 {
 		currentContext := kubeconfig.CurrentContext
 		log.Infof("No context name provided, will use current context: %v", currentContext)
 		return []string{currentContext}, nil
 
 	}
 	namesSet := contextNames(kubeconfig)
+	names := internal.Keys(namesSet)
+	sort.Strings(names)
+
 	if !allContexts {
-		_, found := namesSet[selectedName]
-		if found {
-			log.Infof("Will use context %v", selectedName)
-			return []string{selectedName}, nil
+		selected, err := matchingNames(names, selectedNames)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select contexts: %v", err)
 		}
-		return nil, fmt.Errorf("selected context '%v' is not found in kubeconfig", selectedName)
+		namesSet = selected
 	}
 
-	for _, name := range excludedNames {
+	excluded, err := matchingNames(names, excludedNames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exclude contexts: %v", err)
+	}
+	for name := range excluded {
 		delete(namesSet, name)
 	}
 
-	log.Infof("Will iterate %v contexts", len(namesSet))
-
-	names := internal.Keys(namesSet)
+	names = internal.Keys(namesSet)
 	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no contexts left to scan after excluding %v", excludedNames)
+	}
+	if len(names) == 1 {
+		log.Infof("Will use context %v", names[0])
+	} else {
+		log.Infof("Will iterate %v contexts", len(names))
+	}
 	return names, nil
 }
 
+// matchingNames returns the names matched by any of the patterns, or an error naming a pattern which matches none
+func matchingNames(names []string, patterns []string) (map[string]bool, error) {
+	matched := map[string]bool{}
+	for _, pattern := range patterns {
+		compiled, err := internal.CompileNamePatterns([]string{pattern})
+		if err != nil {
+			return nil, err
+		}
+		if compiled.Empty() {
+			continue
+		}
+		found := false
+		for _, name := range names {
+			if compiled.Matches(name) {
+				matched[name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("'%v' does not match any context in kubeconfig", pattern)
+		}
+	}
+	return matched, nil
+}
+
 func contextNames(kubeconfig KubeConfig) map[string]bool {
 	var names []string
 	for name := range kubeconfig.Contexts {
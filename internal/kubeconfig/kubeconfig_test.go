@@ -1,10 +1,12 @@
 package kubeconfig
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -174,7 +176,7 @@ users:
 
 	names, err := ContextNames(
 		kubeconfig,
-		"c1",
+		[]string{"c1"},
 		false,
 		[]string{},
 	)
@@ -221,7 +223,7 @@ users:
 
 	names, err := ContextNames(
 		kubeconfig,
-		"c2",
+		[]string{"c2"},
 		false,
 		[]string{},
 	)
@@ -268,7 +270,7 @@ users:
 
 	_, err = ContextNames(
 		kubeconfig,
-		"c7",
+		[]string{"c7"},
 		false,
 		[]string{},
 	)
@@ -314,7 +316,7 @@ users:
 
 	names, err := ContextNames(
 		kubeconfig,
-		"",
+		nil,
 		true,
 		[]string{},
 	)
@@ -361,7 +363,7 @@ users:
 
 	names, err := ContextNames(
 		kubeconfig,
-		"c7",
+		[]string{"c7"},
 		true,
 		[]string{},
 	)
@@ -408,7 +410,7 @@ users:
 
 	names, err := ContextNames(
 		kubeconfig,
-		"",
+		nil,
 		true,
 		[]string{"c2"},
 	)
@@ -462,7 +464,7 @@ users:
 
 	names, err := ContextNames(
 		kubeconfig,
-		"",
+		nil,
 		true,
 		[]string{"c3", "c2"},
 	)
@@ -470,3 +472,169 @@ users:
 	require.Nil(t, err)
 	require.Equal(t, []string{"c1", "c4"}, names)
 }
+
+// language=yaml
+const patternsKubeconfigContent = `
+apiVersion: v1
+clusters:
+- cluster:
+    server: ""
+  name: cluster1
+contexts:
+- context:
+    cluster: cluster1
+    user: user
+  name: prod-eu
+- context:
+    cluster: cluster1
+    user: user
+  name: prod-us
+- context:
+    cluster: cluster1
+    user: user
+  name: prod-sandbox
+- context:
+    cluster: cluster1
+    user: user
+  name: staging-1
+- context:
+    cluster: cluster1
+    user: user
+  name: staging-2
+kind: Config
+preferences: {}
+users:
+- name: user
+  user: {}
+`
+
+func TestContextNames_WithPatterns(t *testing.T) {
+	kubeconfig, err := LoadKubeconfig(createKubeconfig(t, patternsKubeconfigContent))
+	require.Nil(t, err)
+
+	names, err := ContextNames(kubeconfig, []string{"prod-*"}, false, []string{"*-sandbox"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"prod-eu", "prod-us"}, names)
+
+	names, err = ContextNames(kubeconfig, []string{"prod-eu", `/^staging-\d$/`}, false, nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"prod-eu", "staging-1", "staging-2"}, names)
+
+	names, err = ContextNames(kubeconfig, nil, true, []string{"prod-*", "staging-2"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"staging-1"}, names)
+}
+
+func TestContextNames_WithPatternsMatchingNothing(t *testing.T) {
+	kubeconfig, err := LoadKubeconfig(createKubeconfig(t, patternsKubeconfigContent))
+	require.Nil(t, err)
+
+	_, err = ContextNames(kubeconfig, []string{"prod-*", "prdo-*"}, false, nil)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "prdo-*")
+
+	_, err = ContextNames(kubeconfig, []string{"prod-*"}, false, []string{"*-sandbx"})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "*-sandbx")
+
+	_, err = ContextNames(kubeconfig, []string{"prod-sandbox"}, false, []string{"*-sandbox"})
+	require.NotNil(t, err)
+
+	_, err = ContextNames(kubeconfig, []string{"/prod-(/"}, false, nil)
+	require.NotNil(t, err)
+}
+
+func kubeconfigWithContext(name string, current bool) string {
+	currentContext := ""
+	if current {
+		currentContext = name
+	}
+	return fmt.Sprintf(`
+apiVersion: v1
+clusters:
+- cluster:
+    server: "https://%[1]v.example.com"
+  name: %[1]v
+contexts:
+- context:
+    cluster: %[1]v
+    user: %[1]v
+  name: %[1]v
+current-context: "%[2]v"
+kind: Config
+users:
+- name: %[1]v
+  user: {}
+`, name, currentContext)
+}
+
+func TestLoadKubeconfig_MergesFilesList(t *testing.T) {
+	dirPath := t.TempDir()
+	firstPath := filepath.Join(dirPath, "a")
+	require.Nil(t, os.WriteFile(firstPath, []byte(kubeconfigWithContext("c1", true)), 0644))
+	secondPath := filepath.Join(dirPath, "b")
+	require.Nil(t, os.WriteFile(secondPath, []byte(kubeconfigWithContext("c2", true)), 0644))
+	missingPath := filepath.Join(dirPath, "missing")
+
+	kubeconfig, err := LoadKubeconfig(strings.Join([]string{firstPath, missingPath, secondPath}, string(filepath.ListSeparator)))
+	require.Nil(t, err)
+	assert.Equal(t, "c1", kubeconfig.CurrentContext)
+	assert.Equal(t, "https://c2.example.com", kubeconfig.Clusters["c2"].Server)
+
+	names, err := ContextNames(kubeconfig, nil, true, nil)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"c1", "c2"}, names)
+}
+
+func TestLoadKubeconfig_Directory(t *testing.T) {
+	dirPath := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dirPath, "b.yaml"), []byte(kubeconfigWithContext("c2", false)), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dirPath, "a.yaml"), []byte(kubeconfigWithContext("c1", true)), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dirPath, ".hidden"), []byte("not a kubeconfig"), 0644))
+
+	kubeconfig, err := LoadKubeconfig(dirPath)
+	require.Nil(t, err)
+	assert.Equal(t, "c1", kubeconfig.CurrentContext)
+
+	names, err := ContextNames(kubeconfig, []string{"c*"}, false, nil)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"c1", "c2"}, names)
+}
+
+func TestLoadKubeconfig_NoFiles(t *testing.T) {
+	_, err := LoadKubeconfig(filepath.Join(t.TempDir(), "missing"))
+	require.NotNil(t, err)
+}
+
+func TestWithCurrentContext(t *testing.T) {
+	// language=yaml
+	content := `
+apiVersion: v1
+clusters:
+- cluster:
+    server: ""
+  name: cluster1
+contexts:
+- context:
+    cluster: cluster1
+    user: user
+  name: c1
+- context:
+    cluster: cluster1
+    user: user
+  name: c2
+current-context: c1
+kind: Config
+preferences: {}
+users:
+- name: user
+`
+	kubeconfigPath := createKubeconfig(t, content)
+	kubeconfig, err := LoadKubeconfig(kubeconfigPath)
+	require.Nil(t, err)
+
+	copied := WithCurrentContext(kubeconfig, "c2")
+	assert.Equal(t, "c2", copied.CurrentContext)
+	assert.Equal(t, "c1", kubeconfig.CurrentContext, "the original kubeconfig is unchanged")
+	assert.Nil(t, WithCurrentContext(nil, "c2"))
+}
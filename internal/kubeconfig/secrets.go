@@ -0,0 +1,188 @@
+package kubeconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"strings"
+)
+
+const (
+	argoCDSecretTypeLabel      = "argocd.argoproj.io/secret-type"
+	argoCDClusterSecretType    = "cluster"
+	argoCDInClusterServer      = "https://kubernetes.default.svc"
+	clusterAPIClusterNameLabel = "cluster.x-k8s.io/cluster-name"
+	clusterAPIKubeconfigKey    = "value"
+	kubeconfigKey              = "kubeconfig"
+)
+
+// argoCDClusterConfig is the 'config' of an Argo CD cluster secret
+type argoCDClusterConfig struct {
+	Username        string `json:"username,omitempty"`
+	Password        string `json:"password,omitempty"`
+	BearerToken     string `json:"bearerToken,omitempty"`
+	TLSClientConfig struct {
+		Insecure   bool   `json:"insecure,omitempty"`
+		ServerName string `json:"serverName,omitempty"`
+		CAData     []byte `json:"caData,omitempty"`
+		CertData   []byte `json:"certData,omitempty"`
+		KeyData    []byte `json:"keyData,omitempty"`
+	} `json:"tlsClientConfig"`
+	AWSAuthConfig *struct {
+		ClusterName string `json:"clusterName"`
+		RoleARN     string `json:"roleARN,omitempty"`
+	} `json:"awsAuthConfig,omitempty"`
+	ExecProviderConfig *struct {
+		Command     string            `json:"command"`
+		Args        []string          `json:"args,omitempty"`
+		Env         map[string]string `json:"env,omitempty"`
+		APIVersion  string            `json:"apiVersion,omitempty"`
+		InstallHint string            `json:"installHint,omitempty"`
+	} `json:"execProviderConfig,omitempty"`
+}
+
+// ClusterFromSecret builds the kubeconfig of a cluster from a secret, which is either an Argo CD cluster secret,
+// a Cluster API kubeconfig secret, or holds a kubeconfig under the 'kubeconfig' key.
+// A nil kubeconfig is returned for an Argo CD secret of the cluster Argo CD runs in, which is the hub itself.
+// Credentials which run a command, such as exec providers, are only accepted if the command is in execCommands,
+// as whoever can create the secret could otherwise run any command as kubescout.
+func ClusterFromSecret(secret *v1.Secret, execCommands []string) (name string, kubeconfig KubeConfig, err error) {
+	switch {
+	case secret.Labels[argoCDSecretTypeLabel] == argoCDClusterSecretType:
+		name, kubeconfig, err = argoCDCluster(secret)
+	case len(secret.Data[clusterAPIKubeconfigKey]) > 0:
+		name = secret.Labels[clusterAPIClusterNameLabel]
+		if name == "" {
+			name = secret.Name
+		}
+		kubeconfig, err = kubeconfigFromSecret(secret, clusterAPIKubeconfigKey)
+	case len(secret.Data[kubeconfigKey]) > 0:
+		name = secret.Name
+		kubeconfig, err = kubeconfigFromSecret(secret, kubeconfigKey)
+	default:
+		return "", nil, fmt.Errorf("secret %v/%v is neither an Argo CD cluster secret nor holds a kubeconfig under '%v' or '%v'",
+			secret.Namespace, secret.Name, kubeconfigKey, clusterAPIKubeconfigKey)
+	}
+	if err != nil || kubeconfig == nil {
+		return name, kubeconfig, err
+	}
+	err = checkExecCommands(secret, kubeconfig, execCommands)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, kubeconfig, nil
+}
+
+// checkExecCommands fails if any credentials of the kubeconfig run a command which is not allowed
+func checkExecCommands(secret *v1.Secret, kubeconfig KubeConfig, execCommands []string) error {
+	allowed := map[string]bool{}
+	for _, command := range execCommands {
+		allowed[command] = true
+	}
+	for authInfoName, authInfo := range kubeconfig.AuthInfos {
+		var command string
+		if authInfo.Exec != nil {
+			command = authInfo.Exec.Command
+			for _, env := range authInfo.Exec.Env {
+				if env.Name == "PATH" || strings.HasPrefix(env.Name, "LD_") || strings.HasPrefix(env.Name, "DYLD_") {
+					return fmt.Errorf("credentials %v of secret %v/%v set %v of the command they run, which is not allowed",
+						authInfoName, secret.Namespace, secret.Name, env.Name)
+				}
+			}
+		} else if authInfo.AuthProvider != nil {
+			command = authInfo.AuthProvider.Config["cmd-path"]
+		}
+		if command == "" || allowed[command] {
+			continue
+		}
+		if len(execCommands) == 0 {
+			return fmt.Errorf("credentials %v of secret %v/%v run '%v', while running commands is not enabled by hub-exec-commands",
+				authInfoName, secret.Namespace, secret.Name, command)
+		}
+		return fmt.Errorf("credentials %v of secret %v/%v run '%v', which is not one of hub-exec-commands",
+			authInfoName, secret.Namespace, secret.Name, command)
+	}
+	return nil
+}
+
+func kubeconfigFromSecret(secret *v1.Secret, key string) (KubeConfig, error) {
+	kubeconfig, err := clientcmd.Load(secret.Data[key])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig from secret %v/%v: %v", secret.Namespace, secret.Name, err)
+	}
+	if kubeconfig.CurrentContext == "" && len(kubeconfig.Contexts) == 1 {
+		for contextName := range kubeconfig.Contexts {
+			kubeconfig.CurrentContext = contextName
+		}
+	}
+	if _, found := kubeconfig.Contexts[kubeconfig.CurrentContext]; !found {
+		return nil, fmt.Errorf("kubeconfig of secret %v/%v has no current context", secret.Namespace, secret.Name)
+	}
+	return kubeconfig, nil
+}
+
+func argoCDCluster(secret *v1.Secret) (string, KubeConfig, error) {
+	server := string(secret.Data["server"])
+	if server == "" {
+		return "", nil, fmt.Errorf("argo cd cluster secret %v/%v has no server", secret.Namespace, secret.Name)
+	}
+	name := string(secret.Data["name"])
+	if name == "" {
+		name = server
+	}
+	if server == argoCDInClusterServer {
+		return name, nil, nil
+	}
+
+	var argoConfig argoCDClusterConfig
+	if len(secret.Data["config"]) > 0 {
+		err := json.Unmarshal(secret.Data["config"], &argoConfig)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to deserialize config of argo cd cluster secret %v/%v: %v", secret.Namespace, secret.Name, err)
+		}
+	}
+
+	authInfo := &clientcmdapi.AuthInfo{
+		Token:                 argoConfig.BearerToken,
+		Username:              argoConfig.Username,
+		Password:              argoConfig.Password,
+		ClientCertificateData: argoConfig.TLSClientConfig.CertData,
+		ClientKeyData:         argoConfig.TLSClientConfig.KeyData,
+	}
+	if exec := argoConfig.ExecProviderConfig; exec != nil {
+		authInfo.Exec = &clientcmdapi.ExecConfig{
+			Command:     exec.Command,
+			Args:        exec.Args,
+			APIVersion:  exec.APIVersion,
+			InstallHint: exec.InstallHint,
+		}
+		for envName, envValue := range exec.Env {
+			authInfo.Exec.Env = append(authInfo.Exec.Env, clientcmdapi.ExecEnvVar{Name: envName, Value: envValue})
+		}
+	} else if aws := argoConfig.AWSAuthConfig; aws != nil {
+		// argo cd authenticates to eks with its own binary, the aws cli issues the same tokens
+		args := []string{"eks", "get-token", "--cluster-name", aws.ClusterName}
+		if aws.RoleARN != "" {
+			args = append(args, "--role-arn", aws.RoleARN)
+		}
+		authInfo.Exec = &clientcmdapi.ExecConfig{
+			Command:    "aws",
+			Args:       args,
+			APIVersion: "client.authentication.k8s.io/v1beta1",
+		}
+	}
+
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[name] = &clientcmdapi.Cluster{
+		Server:                   server,
+		CertificateAuthorityData: argoConfig.TLSClientConfig.CAData,
+		InsecureSkipTLSVerify:    argoConfig.TLSClientConfig.Insecure,
+		TLSServerName:            argoConfig.TLSClientConfig.ServerName,
+	}
+	kubeconfig.AuthInfos[name] = authInfo
+	kubeconfig.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	kubeconfig.CurrentContext = name
+	return name, kubeconfig, nil
+}
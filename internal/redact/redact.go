@@ -0,0 +1,122 @@
+package redact
+
+import (
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	"regexp"
+)
+
+const Replacement = "[REDACTED]"
+
+// patterns with a group named 'secret' only have that group redacted, otherwise the entire match is redacted
+var builtinPatterns = []string{
+	// private keys, possibly cut off by the logs tail
+	`(?s)-----BEGIN[A-Z ]*PRIVATE KEY-----.*?(-----END[A-Z ]*PRIVATE KEY-----|$)`,
+	// json web tokens
+	`\beyJ[\w-]+\.eyJ[\w-]+\.[\w-]+`,
+	// aws access key ids
+	`\b(AKIA|ASIA)[0-9A-Z]{16}\b`,
+	// aws secret access keys
+	`(?i)aws_?secret_?(access_?)?key["']?\s*[=:]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`,
+	// bearer tokens
+	`(?i)\bbearer\s+(?P<secret>[\w\-.~+/]+=*)`,
+	// passwords in connection strings
+	`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^\s:/@]+:(?P<secret>[^\s@/]+)@`,
+}
+
+type detector struct {
+	regex       *regexp.Regexp
+	secretGroup int
+}
+
+type Redactor struct {
+	detectors []*detector
+}
+
+// NewRedactor creates a redactor with the builtin detectors and the given additional patterns
+func NewRedactor(patterns []string) (*Redactor, error) {
+	redactor := &Redactor{}
+	for _, pattern := range append(append([]string{}, builtinPatterns...), patterns...) {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile redaction pattern '%v': %v", pattern, err)
+		}
+		redactor.detectors = append(redactor.detectors, &detector{
+			regex:       regex,
+			secretGroup: regex.SubexpIndex("secret"),
+		})
+	}
+	return redactor, nil
+}
+
+func (d *detector) redact(text string) (string, int) {
+	matches := d.regex.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text, 0
+	}
+	count := 0
+	redacted := make([]byte, 0, len(text))
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if d.secretGroup > 0 {
+			start, end = match[2*d.secretGroup], match[2*d.secretGroup+1]
+			if start < 0 {
+				continue
+			}
+		}
+		if start == end || text[start:end] == Replacement {
+			continue
+		}
+		redacted = append(redacted, text[last:start]...)
+		redacted = append(redacted, Replacement...)
+		last = end
+		count++
+	}
+	redacted = append(redacted, text[last:]...)
+	return string(redacted), count
+}
+
+// Redact replaces all detected secrets in text and returns the redacted text along with the redactions count
+func (redactor *Redactor) Redact(text string) (string, int) {
+	total := 0
+	for _, d := range redactor.detectors {
+		var count int
+		text, count = d.redact(text)
+		total += count
+	}
+	return text, total
+}
+
+func (redactor *Redactor) redactAll(texts []string) (count int) {
+	for i, text := range texts {
+		var n int
+		texts[i], n = redactor.Redact(text)
+		count += n
+	}
+	return
+}
+
+// RedactEntityAlert redacts the alert content in place and adds to its redactions count
+func (redactor *Redactor) RedactEntityAlert(entityAlert *alert.EntityAlert) {
+	count := redactor.redactAll(entityAlert.Messages)
+	count += redactor.redactAll(entityAlert.Events)
+	for containerName, logs := range entityAlert.LogsByContainerName {
+		var n int
+		entityAlert.LogsByContainerName[containerName], n = redactor.Redact(logs)
+		count += n
+	}
+	var n int
+	entityAlert.LikelyCause, n = redactor.Redact(entityAlert.LikelyCause)
+	count += n
+	entityAlert.Redactions += count
+}
+
+// RedactAlerts redacts all alerts in place
+func (redactor *Redactor) RedactAlerts(alerts *alert.Alerts) {
+	for _, entityAlerts := range alerts.AlertsByClusterName {
+		for _, entityAlert := range entityAlerts {
+			redactor.RedactEntityAlert(entityAlert)
+		}
+	}
+}
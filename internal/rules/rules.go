@@ -0,0 +1,178 @@
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	"github.com/reallyliri/kubescout/alert"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	"text/template"
+)
+
+type Match struct {
+	Kind       string            `yaml:"kind"`
+	Namespaces []string          `yaml:"namespaces"`
+	Labels     map[string]string `yaml:"labels"`
+}
+
+type Rule struct {
+	Name     string `yaml:"name"`
+	Match    Match  `yaml:"match"`
+	Expr     string `yaml:"expr"`
+	Message  string `yaml:"message"`
+	Severity string `yaml:"severity"`
+
+	program  *vm.Program
+	template *template.Template
+	severity alert.Severity
+}
+
+type RuleSet struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Result of a rule that matched an object
+type Result struct {
+	RuleName string
+	Message  string
+	Severity alert.Severity
+}
+
+// Load parses and compiles the rules file, an empty path results in an empty rule set
+func Load(filePath string) (*RuleSet, error) {
+	ruleSet := &RuleSet{}
+	if filePath == "" {
+		return ruleSet, nil
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file from '%v': %v", filePath, err)
+	}
+	err = yaml.Unmarshal(content, ruleSet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize yaml from '%v': %v", filePath, err)
+	}
+	err = ruleSet.compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules file '%v': %v", filePath, err)
+	}
+	return ruleSet, nil
+}
+
+func (ruleSet *RuleSet) compile() error {
+	names := map[string]bool{}
+	for i, rule := range ruleSet.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule #%v has no name", i+1)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule name '%v' is not unique", rule.Name)
+		}
+		names[rule.Name] = true
+		err := rule.compile()
+		if err != nil {
+			return fmt.Errorf("rule '%v': %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (rule *Rule) compile() (err error) {
+	if rule.Match.Kind == "" {
+		return fmt.Errorf("match kind is required")
+	}
+	if rule.Expr == "" {
+		return fmt.Errorf("expr is required")
+	}
+	rule.program, err = expr.Compile(rule.Expr, expr.AsBool())
+	if err != nil {
+		return fmt.Errorf("failed to compile expr: %v", err)
+	}
+	message := rule.Message
+	if message == "" {
+		message = fmt.Sprintf("Matched rule %v", rule.Name)
+	}
+	rule.template, err = template.New(rule.Name).Option("missingkey=zero").Parse(message)
+	if err != nil {
+		return fmt.Errorf("failed to parse message template: %v", err)
+	}
+	rule.severity = alert.SeverityWarning
+	if rule.Severity != "" {
+		rule.severity, err = alert.ParseSeverity(rule.Severity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ruleSet *RuleSet) Empty() bool {
+	return len(ruleSet.Rules) == 0
+}
+
+func (match *Match) matches(kind string, namespace string, labels map[string]string) bool {
+	if match.Kind != kind {
+		return false
+	}
+	if len(match.Namespaces) > 0 {
+		found := false
+		for _, candidate := range match.Namespaces {
+			if candidate == namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for key, value := range match.Labels {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate runs all rules that match the kind, namespace and labels against the raw object, a failing rule does not stop the others
+func (ruleSet *RuleSet) Evaluate(kind string, namespace string, labels map[string]string, object interface{}) (results []Result, err error) {
+	var env map[string]interface{}
+	for _, rule := range ruleSet.Rules {
+		if !rule.Match.matches(kind, namespace, labels) {
+			continue
+		}
+		if env == nil {
+			unstructured, convertErr := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+			if convertErr != nil {
+				return nil, fmt.Errorf("failed to convert %v object to unstructured: %v", kind, convertErr)
+			}
+			env = map[string]interface{}{
+				"object": unstructured,
+			}
+		}
+		output, runErr := expr.Run(rule.program, env)
+		if runErr != nil {
+			err = multierr.Append(err, fmt.Errorf("failed to evaluate rule '%v': %v", rule.Name, runErr))
+			continue
+		}
+		if matched, _ := output.(bool); !matched {
+			continue
+		}
+		message := bytes.Buffer{}
+		templateErr := rule.template.Execute(&message, env)
+		if templateErr != nil {
+			err = multierr.Append(err, fmt.Errorf("failed to render message of rule '%v': %v", rule.Name, templateErr))
+			continue
+		}
+		results = append(results, Result{
+			RuleName: rule.Name,
+			Message:  message.String(),
+			Severity: rule.severity,
+		})
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package rules
+
+import (
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	"testing"
+)
+
+func writeRules(t *testing.T, content string) string {
+	filePath := path.Join(t.TempDir(), "rules.yaml")
+	err := ioutil.WriteFile(filePath, []byte(content), 0644)
+	require.Nil(t, err)
+	return filePath
+}
+
+func testPod(restartCount int32) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      "api-1",
+			Namespace: "prod",
+			Labels:    map[string]string{"app": "api"},
+		},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "sidecar", RestartCount: 0},
+				{Name: "api", RestartCount: restartCount},
+			},
+		},
+	}
+}
+
+// language=yaml
+const restartsRules = `
+rules:
+  - name: too-many-restarts
+    match:
+      kind: Pod
+      namespaces: [prod]
+      labels:
+        app: api
+    expr: any(object.status.containerStatuses, {.restartCount > 10})
+    message: "Pod {{ .object.metadata.name }} restarted more than 10 times"
+    severity: critical
+`
+
+func TestLoad_EmptyPath(t *testing.T) {
+	ruleSet, err := Load("")
+	require.Nil(t, err)
+	require.True(t, ruleSet.Empty())
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	_, err := Load(writeRules(t, "rules:\n  - name: no-kind\n    expr: 'true'\n"))
+	require.NotNil(t, err)
+	_, err = Load(writeRules(t, "rules:\n  - name: bad-expr\n    match:\n      kind: Pod\n    expr: 'object.'\n"))
+	require.NotNil(t, err)
+	_, err = Load(writeRules(t, "rules:\n  - name: bad-severity\n    match:\n      kind: Pod\n    expr: 'true'\n    severity: urgent\n"))
+	require.NotNil(t, err)
+	_, err = Load(path.Join(t.TempDir(), "missing.yaml"))
+	require.NotNil(t, err)
+}
+
+func TestEvaluate(t *testing.T) {
+	ruleSet, err := Load(writeRules(t, restartsRules))
+	require.Nil(t, err)
+	require.False(t, ruleSet.Empty())
+
+	pod := testPod(11)
+	results, err := ruleSet.Evaluate("Pod", pod.Namespace, pod.Labels, pod)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(results))
+	assert.Equal(t, "too-many-restarts", results[0].RuleName)
+	assert.Equal(t, "Pod api-1 restarted more than 10 times", results[0].Message)
+	assert.Equal(t, alert.SeverityCritical, results[0].Severity)
+
+	pod = testPod(3)
+	results, err = ruleSet.Evaluate("Pod", pod.Namespace, pod.Labels, pod)
+	require.Nil(t, err)
+	require.Empty(t, results)
+}
+
+func TestEvaluate_NoMatch(t *testing.T) {
+	ruleSet, err := Load(writeRules(t, restartsRules))
+	require.Nil(t, err)
+
+	pod := testPod(11)
+	results, err := ruleSet.Evaluate("Pod", "dev", pod.Labels, pod)
+	require.Nil(t, err)
+	require.Empty(t, results)
+
+	results, err = ruleSet.Evaluate("Pod", pod.Namespace, map[string]string{"app": "web"}, pod)
+	require.Nil(t, err)
+	require.Empty(t, results)
+
+	results, err = ruleSet.Evaluate("ReplicaSet", pod.Namespace, pod.Labels, pod)
+	require.Nil(t, err)
+	require.Empty(t, results)
+}
@@ -0,0 +1,31 @@
+package store
+
+// Scope is of the kinds of entities a diagnosis listed per namespace, where cluster scoped kinds are of no namespace.
+// A namespace entity is in the scope of its own name.
+type Scope map[string]map[string]bool
+
+func (scope Scope) Add(namespace string, kind string) {
+	kinds, found := scope[namespace]
+	if !found {
+		kinds = map[string]bool{}
+		scope[namespace] = kinds
+	}
+	kinds[kind] = true
+}
+
+func (scope Scope) Contains(entityName EntityName) bool {
+	namespace := entityName.Namespace
+	if entityName.Kind == "Namespace" {
+		namespace = entityName.Name
+	}
+	return scope[namespace][entityName.Kind]
+}
+
+// Merge the other scope into this one
+func (scope Scope) Merge(other Scope) {
+	for namespace, kinds := range other {
+		for kind := range kinds {
+			scope.Add(namespace, kind)
+		}
+	}
+}
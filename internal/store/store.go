@@ -7,9 +7,12 @@ import (
 	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/config"
 	"github.com/reallyliri/kubescout/internal/dedup"
+	"github.com/reallyliri/kubescout/internal/redact"
 	log "github.com/sirupsen/logrus"
 	"io/fs"
 	"io/ioutil"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -18,20 +21,47 @@ type Store struct {
 	LastRunAt           time.Time                `json:"last_run_at"`
 	dedupDuration       time.Duration
 	filePath            string
+	// messages are redacted like the reported alerts, so secrets are not written to the store file
+	redactor *redact.Redactor
+	// guards the clusters stores map, as clusters are diagnosed concurrently
+	lock sync.Mutex
+	// cluster stores as last written to the file by FlushCluster, or as loaded from it
+	flushedClusterStores map[string]json.RawMessage
 }
 
 type ClusterStore struct {
 	parent                         *Store
-	Cluster                        string                              `json:"cluster"`
-	Alerts                         alert.EntityAlerts                  `json:"-"`
+	Cluster                        string                          `json:"cluster"`
+	Alerts                         alert.EntityAlerts              `json:"-"`
+	Capabilities                   *alert.ClusterCapabilities      `json:"-"`
 	MessagesWithTimestampPerEntity map[string]map[string]time.Time `json:"messages_with_timestamp_per_entity"`
+	DedupDurationPerEntity         map[string]time.Duration        `json:"dedup_duration_per_entity,omitempty"`
+	FiringAlertsPerEntity          map[string]*FiringAlert         `json:"firing_alerts_per_entity,omitempty"`
+	// LastRunAt is the time of the last complete diagnosis of the cluster, or zero if there was none.
+	// It is kept per cluster, as clusters are diagnosed and fail independently of each other.
+	LastRunAt time.Time `json:"last_run_at"`
+}
+
+// FiringAlert is of an entity that was alerted on, and was not seen healthy or gone since.
+// Fingerprints are of all the alerts fired for the entity, in the order they were first fired, so each can be resolved.
+type FiringAlert struct {
+	Entity       EntityName     `json:"entity"`
+	Fingerprints []string       `json:"fingerprints"`
+	Severity     alert.Severity `json:"severity"`
+	Since        time.Time      `json:"since"`
 }
 
 func LoadOrCreate(config *config.Config) (*Store, error) {
+	redactor, err := redact.NewRedactor(config.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
 	store := &Store{
-		ClusterStoresByName: make(map[string]*ClusterStore),
-		dedupDuration:       config.MessagesDeduplicationDuration,
-		filePath:            config.StoreFilePath,
+		ClusterStoresByName:  make(map[string]*ClusterStore),
+		dedupDuration:        config.MessagesDeduplicationDuration,
+		filePath:             config.StoreFilePath,
+		redactor:             redactor,
+		flushedClusterStores: make(map[string]json.RawMessage),
 	}
 	if store.filePath == "" {
 		return store, nil
@@ -52,10 +82,62 @@ func LoadOrCreate(config *config.Config) (*Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to deserialize json from '%v': %v", store.filePath, err)
 	}
+	flushed := flushedStore{}
+	err = json.Unmarshal(content, &flushed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize json from '%v': %v", store.filePath, err)
+	}
+	if flushed.ClusterStoresByName != nil {
+		store.flushedClusterStores = flushed.ClusterStoresByName
+	}
+	err = store.redactLoaded()
+	if err != nil {
+		return nil, err
+	}
 	return store, nil
 }
 
+// redactLoaded redacts the messages of a store file written before they were redacted, or with other redaction patterns
+func (store *Store) redactLoaded() error {
+	for name, clusterStore := range store.ClusterStoresByName {
+		redacted := false
+		for _, messagesByTimestamp := range clusterStore.MessagesWithTimestampPerEntity {
+			for message, timestamp := range messagesByTimestamp {
+				redactedMessage, count := store.redactor.Redact(message)
+				if count == 0 {
+					continue
+				}
+				delete(messagesByTimestamp, message)
+				if existing, found := messagesByTimestamp[redactedMessage]; !found || existing.Before(timestamp) {
+					messagesByTimestamp[redactedMessage] = timestamp
+				}
+				redacted = true
+			}
+		}
+		if !redacted {
+			continue
+		}
+		if _, found := store.flushedClusterStores[name]; found {
+			content, err := json.Marshal(clusterStore)
+			if err != nil {
+				return fmt.Errorf("failed to serialize store of cluster %v to json: %v", name, err)
+			}
+			store.flushedClusterStores[name] = content
+		}
+	}
+	return nil
+}
+
+// flushedStore is the file content of a store, with the cluster stores as they were flushed
+type flushedStore struct {
+	ClusterStoresByName map[string]json.RawMessage `json:"cluster_stores_by_name"`
+	LastRunAt           time.Time                  `json:"last_run_at"`
+}
+
 func (store *Store) GetClusterStore(name string, now time.Time) *ClusterStore {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
 	clusterStore, exists := store.ClusterStoresByName[name]
 	if !exists {
 		clusterStore = &ClusterStore{
@@ -66,14 +148,24 @@ func (store *Store) GetClusterStore(name string, now time.Time) *ClusterStore {
 		store.ClusterStoresByName[name] = clusterStore
 	}
 	clusterStore.parent = store
+	clusterStore.Alerts = []*alert.EntityAlert{}
+	clusterStore.Capabilities = nil
+	if clusterStore.DedupDurationPerEntity == nil {
+		clusterStore.DedupDurationPerEntity = make(map[string]time.Duration)
+	}
+	if clusterStore.FiringAlertsPerEntity == nil {
+		clusterStore.FiringAlertsPerEntity = make(map[string]*FiringAlert)
+	}
 	for entityName, messagesByTimestamp := range clusterStore.MessagesWithTimestampPerEntity {
+		dedupDuration := clusterStore.entityDedupDuration(entityName)
 		for message, timestamp := range messagesByTimestamp {
-			if store.dedupDuration > 0 && now.Sub(timestamp) > store.dedupDuration {
+			if dedupDuration > 0 && now.Sub(timestamp) > dedupDuration {
 				delete(messagesByTimestamp, message)
 			}
 		}
 		if len(messagesByTimestamp) == 0 {
 			delete(clusterStore.MessagesWithTimestampPerEntity, entityName)
+			delete(clusterStore.DedupDurationPerEntity, entityName)
 		}
 	}
 	return clusterStore
@@ -93,7 +185,26 @@ func tryMatch(messagesByTimestamp map[string]time.Time, candidate string) (match
 	return ""
 }
 
+func (clusterStore *ClusterStore) entityDedupDuration(entityName string) time.Duration {
+	if dedupDuration, found := clusterStore.DedupDurationPerEntity[entityName]; found {
+		return dedupDuration
+	}
+	return clusterStore.parent.dedupDuration
+}
+
 func (clusterStore *ClusterStore) TryAdd(entityName EntityName, message string, now time.Time) bool {
+	return clusterStore.TryAddWithDedupDuration(entityName, message, now, clusterStore.parent.dedupDuration)
+}
+
+// TryAddWithDedupDuration is like TryAdd, but with a dedup duration specific to the entity
+func (clusterStore *ClusterStore) TryAddWithDedupDuration(entityName EntityName, message string, now time.Time, dedupDuration time.Duration) bool {
+	if dedupDuration == clusterStore.parent.dedupDuration {
+		delete(clusterStore.DedupDurationPerEntity, entityName.String())
+	} else {
+		clusterStore.DedupDurationPerEntity[entityName.String()] = dedupDuration
+	}
+
+	message, _ = clusterStore.parent.redactor.Redact(message)
 	message = dedup.NormalizeTemporal(message)
 	truncMessage := message
 	if len(message) > 50 {
@@ -113,7 +224,7 @@ func (clusterStore *ClusterStore) TryAdd(entityName EntityName, message string,
 	match := tryMatch(messagesByTimestamp, message)
 	if match != "" {
 		timestamp := messagesByTimestamp[match]
-		if clusterStore.parent.dedupDuration > 0 && now.Sub(timestamp) <= clusterStore.parent.dedupDuration {
+		if dedupDuration > 0 && now.Sub(timestamp) <= dedupDuration {
 			log.Tracef("match was found for message '%v' for entity %v and its timestamp is in dedup grace time - skipping", truncMessage, entityName)
 			return false
 		}
@@ -127,6 +238,56 @@ func (clusterStore *ClusterStore) TryAdd(entityName EntityName, message string,
 	return true
 }
 
+// Fire tracks the entity of an alert as firing, since the earliest time it was alerted on
+func (clusterStore *ClusterStore) Fire(entityName EntityName, entityAlert *alert.EntityAlert) {
+	firing, found := clusterStore.FiringAlertsPerEntity[entityName.String()]
+	if !found {
+		firing = &FiringAlert{
+			Entity: entityName,
+			Since:  entityAlert.Timestamp,
+		}
+		clusterStore.FiringAlertsPerEntity[entityName.String()] = firing
+	}
+	fired := false
+	for _, fingerprint := range firing.Fingerprints {
+		if fingerprint == entityAlert.Fingerprint {
+			fired = true
+			break
+		}
+	}
+	if !fired {
+		firing.Fingerprints = append(firing.Fingerprints, entityAlert.Fingerprint)
+	}
+	firing.Severity = entityAlert.Severity
+	if entityAlert.Timestamp.Before(firing.Since) {
+		firing.Since = entityAlert.Timestamp
+	}
+}
+
+// Forget stops tracking the entity as firing, without resolving it
+func (clusterStore *ClusterStore) Forget(entityName EntityName) {
+	delete(clusterStore.FiringAlertsPerEntity, entityName.String())
+}
+
+// ResolveExcept stops tracking the firing entities within the scanned scope which are not still firing,
+// and returns them sorted by entity, with all the fingerprints they fired with. Their deduplicated messages are forgotten, so a recurring problem is alerted on again.
+// Entities out of the scope are not known to be healthy or gone, and are kept firing.
+func (clusterStore *ClusterStore) ResolveExcept(scanned Scope, stillFiring map[EntityName]bool) (resolved []*FiringAlert) {
+	for key, firing := range clusterStore.FiringAlertsPerEntity {
+		if stillFiring[firing.Entity] || !scanned.Contains(firing.Entity) {
+			continue
+		}
+		resolved = append(resolved, firing)
+		delete(clusterStore.FiringAlertsPerEntity, key)
+		delete(clusterStore.MessagesWithTimestampPerEntity, key)
+		delete(clusterStore.DedupDurationPerEntity, key)
+	}
+	sort.Slice(resolved, func(i, j int) bool {
+		return resolved[i].Entity.String() < resolved[j].Entity.String()
+	})
+	return
+}
+
 func (store *Store) Flush(now time.Time) error {
 
 	store.LastRunAt = now
@@ -135,7 +296,29 @@ func (store *Store) Flush(now time.Time) error {
 		return nil
 	}
 
-	content, err := json.MarshalIndent(store, "", " ")
+	return store.write(store.filePath)
+}
+
+// FlushCluster writes the cluster store to the file, along with the other clusters as they were last flushed,
+// so the state of a cluster in the middle of its diagnosis is not written by another cluster.
+// It is used instead of Flush when clusters are diagnosed and reported independently of each other.
+func (store *Store) FlushCluster(clusterStore *ClusterStore, now time.Time) error {
+	content, err := json.Marshal(clusterStore)
+	if err != nil {
+		return fmt.Errorf("failed to serialize store of cluster %v to json: %v", clusterStore.Cluster, err)
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.flushedClusterStores[clusterStore.Cluster] = content
+	store.LastRunAt = now
+
+	if store.filePath == "" {
+		return nil
+	}
+
+	content, err = json.MarshalIndent(flushedStore{ClusterStoresByName: store.flushedClusterStores, LastRunAt: now}, "", " ")
 	if err != nil {
 		return fmt.Errorf("failed to serialize store to json: %v", err)
 	}
@@ -145,3 +328,43 @@ func (store *Store) Flush(now time.Time) error {
 	}
 	return nil
 }
+
+// RevertCluster discards the changes to the cluster store since it was last flushed by FlushCluster or loaded,
+// e.g. so the alerts which failed to be reported are not deduplicated on the next diagnosis
+func (store *Store) RevertCluster(name string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	content, found := store.flushedClusterStores[name]
+	if !found {
+		delete(store.ClusterStoresByName, name)
+		return nil
+	}
+	clusterStore := &ClusterStore{}
+	err := json.Unmarshal(content, clusterStore)
+	if err != nil {
+		return fmt.Errorf("failed to deserialize store of cluster %v: %v", name, err)
+	}
+	store.ClusterStoresByName[name] = clusterStore
+	return nil
+}
+
+// Snapshot writes the current state of the store to another file, leaving the store file as is
+func (store *Store) Snapshot(filePath string) error {
+	return store.write(filePath)
+}
+
+func (store *Store) write(filePath string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	content, err := json.MarshalIndent(store, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize store to json: %v", err)
+	}
+	err = ioutil.WriteFile(filePath, content, 0777)
+	if err != nil {
+		return fmt.Errorf("failed to write json content to '%v': %v", filePath, err)
+	}
+	return nil
+}
@@ -1,9 +1,12 @@
 package store
 
 import (
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/config"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
+	"sync"
 	"testing"
 	"time"
 )
@@ -170,6 +173,74 @@ func TestStoreForMultipleClusters(t *testing.T) {
 	require.Nil(t, err)
 }
 
+func TestStoreForConcurrentClusters(t *testing.T) {
+	now := time.Now().UTC()
+	storeFile, err := ioutil.TempFile(t.TempDir(), "*.store.json")
+	require.Nil(t, err)
+
+	cfg := &config.Config{
+		StoreFilePath:                 storeFile.Name(),
+		MessagesDeduplicationDuration: time.Minute,
+	}
+	store, err := LoadOrCreate(cfg)
+	require.Nil(t, err)
+
+	name := EntityName{Name: "ent1"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(cluster string) {
+			defer wg.Done()
+			clusterStore := store.GetClusterStore(cluster, now)
+			clusterStore.TryAdd(name, "a", now)
+		}(fmt.Sprintf("test-%v", i))
+	}
+	wg.Wait()
+	require.Equal(t, 8, len(store.ClusterStoresByName))
+
+	err = store.Flush(now)
+	require.Nil(t, err)
+}
+
+func TestFlushAndRevertCluster(t *testing.T) {
+	now := time.Now().UTC()
+	storeFile, err := ioutil.TempFile(t.TempDir(), "*.store.json")
+	require.Nil(t, err)
+
+	cfg := &config.Config{
+		StoreFilePath:                 storeFile.Name(),
+		MessagesDeduplicationDuration: time.Minute,
+	}
+	store, err := LoadOrCreate(cfg)
+	require.Nil(t, err)
+	name := EntityName{Name: "ent1"}
+
+	cluster1Store := store.GetClusterStore("test-1", now)
+	require.True(t, cluster1Store.TryAdd(name, "a", now))
+	cluster2Store := store.GetClusterStore("test-2", now)
+	require.True(t, cluster2Store.TryAdd(name, "a", now))
+
+	// the second cluster is in the middle of its diagnosis, and is not written along with the first
+	err = store.FlushCluster(cluster1Store, now)
+	require.Nil(t, err)
+	storeReloaded, err := LoadOrCreate(cfg)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(storeReloaded.ClusterStoresByName))
+	require.False(t, storeReloaded.GetClusterStore("test-1", now).TryAdd(name, "a", now))
+
+	// the unreported messages of the second cluster are reverted, while the first cluster is kept as is
+	require.True(t, cluster1Store.TryAdd(name, "b", now))
+	err = store.RevertCluster("test-2")
+	require.Nil(t, err)
+	require.True(t, store.GetClusterStore("test-2", now).TryAdd(name, "a", now))
+	require.False(t, store.GetClusterStore("test-1", now).TryAdd(name, "b", now))
+
+	err = store.RevertCluster("test-1")
+	require.Nil(t, err)
+	require.True(t, store.GetClusterStore("test-1", now).TryAdd(name, "b", now))
+	require.False(t, store.GetClusterStore("test-1", now).TryAdd(name, "a", now))
+}
+
 func TestJsonContent(t *testing.T) {
 	time.Local = time.UTC
 	now, err := time.Parse(time.RFC822, "17 Oct 21 13:00 IDT")
@@ -203,6 +274,7 @@ func TestJsonContent(t *testing.T) {
 	}
 	require.True(t, clusterStore.TryAdd(name2, "a", now))
 	require.Equal(t, 1, len(clusterStore.MessagesWithTimestampPerEntity[name2.String()]))
+	clusterStore.LastRunAt = now
 
 	err = store.Flush(now.Add(time.Minute))
 	require.Nil(t, err)
@@ -223,10 +295,140 @@ func TestJsonContent(t *testing.T) {
      "b": "2021-10-17T13:00:00Z",
      "c": "2021-10-17T13:00:00Z"
     }
-   }
+   },
+   "last_run_at": "2021-10-17T13:00:00Z"
   }
  },
  "last_run_at": "2021-10-17T13:01:00Z"
 }`
 	require.Equal(t, expectedContent, string(content))
 }
+
+func TestStoreAddWithEntityDedupDuration(t *testing.T) {
+	storeFile, err := ioutil.TempFile(t.TempDir(), "*.store.json")
+	require.Nil(t, err)
+	now := time.Now().UTC()
+
+	cfg := &config.Config{
+		StoreFilePath:                 storeFile.Name(),
+		MessagesDeduplicationDuration: time.Minute,
+	}
+	store, err := LoadOrCreate(cfg)
+	require.Nil(t, err)
+
+	clusterStore := store.GetClusterStore("test", now)
+
+	name := EntityName{Name: "ent1"}
+
+	require.True(t, clusterStore.TryAddWithDedupDuration(name, "m", now, time.Hour))
+	require.False(t, clusterStore.TryAddWithDedupDuration(name, "m", now.Add(time.Minute*time.Duration(2)), time.Hour))
+
+	err = store.Flush(now)
+	require.Nil(t, err)
+
+	storeReloaded, err := LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStoreReloaded := storeReloaded.GetClusterStore("test", now.Add(time.Minute*time.Duration(30)))
+	require.Equal(t, 1, len(clusterStoreReloaded.MessagesWithTimestampPerEntity[name.String()]))
+	require.False(t, clusterStoreReloaded.TryAddWithDedupDuration(name, "m", now.Add(time.Minute*time.Duration(30)), time.Hour))
+	require.True(t, clusterStoreReloaded.TryAddWithDedupDuration(name, "m", now.Add(time.Minute*time.Duration(61)), time.Hour))
+}
+
+func TestStoreFiringAlerts(t *testing.T) {
+	storeFile, err := ioutil.TempFile(t.TempDir(), "*.store.json")
+	require.Nil(t, err)
+	now := time.Now().UTC()
+
+	cfg := &config.Config{
+		StoreFilePath:                 storeFile.Name(),
+		MessagesDeduplicationDuration: time.Hour,
+	}
+	store, err := LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := store.GetClusterStore("test", now)
+
+	name1 := EntityName{Kind: "Pod", Namespace: "ns", Name: "po1"}
+	name2 := EntityName{Kind: "Pod", Namespace: "ns", Name: "po2"}
+	require.True(t, clusterStore.TryAdd(name1, "m", now))
+	clusterStore.Fire(name1, &alert.EntityAlert{Fingerprint: "f1", Severity: alert.SeverityWarning, Timestamp: now})
+	clusterStore.Fire(name2, &alert.EntityAlert{Fingerprint: "f2", Severity: alert.SeverityCritical, Timestamp: now})
+	clusterStore.Fire(name1, &alert.EntityAlert{Fingerprint: "f1b", Severity: alert.SeverityCritical, Timestamp: now.Add(time.Minute)})
+	clusterStore.Fire(name1, &alert.EntityAlert{Fingerprint: "f1", Severity: alert.SeverityCritical, Timestamp: now.Add(time.Minute)})
+
+	err = store.Flush(now)
+	require.Nil(t, err)
+	store, err = LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore = store.GetClusterStore("test", now.Add(time.Minute))
+	require.Equal(t, 2, len(clusterStore.FiringAlertsPerEntity))
+
+	name3 := EntityName{Kind: "Pod", Namespace: "other", Name: "po3"}
+	clusterStore.Fire(name3, &alert.EntityAlert{Fingerprint: "f3", Severity: alert.SeverityWarning, Timestamp: now})
+	scanned := Scope{}
+	scanned.Add("ns", "Pod")
+
+	resolved := clusterStore.ResolveExcept(scanned, map[EntityName]bool{name2: true})
+	require.Equal(t, 1, len(resolved))
+	require.Equal(t, name1, resolved[0].Entity)
+	require.Equal(t, []string{"f1", "f1b"}, resolved[0].Fingerprints, "every fingerprint the entity fired with is resolved")
+	require.Equal(t, alert.SeverityCritical, resolved[0].Severity)
+	require.True(t, now.Equal(resolved[0].Since), "firing since it was first alerted on")
+	require.True(t, clusterStore.TryAdd(name1, "m", now.Add(time.Minute)), "a resolved entity is alerted on again")
+
+	require.NotNil(t, clusterStore.FiringAlertsPerEntity[name3.String()], "entities out of the scanned scope are kept firing")
+
+	clusterStore.Forget(name2)
+	require.Empty(t, clusterStore.ResolveExcept(scanned, nil))
+}
+
+func TestFlushRedactsMessages(t *testing.T) {
+	storeFile, err := ioutil.TempFile(t.TempDir(), "*.store.json")
+	require.Nil(t, err)
+	now := time.Now().UTC()
+
+	cfg := &config.Config{
+		StoreFilePath:                 storeFile.Name(),
+		MessagesDeduplicationDuration: time.Minute,
+		RedactPatterns:                []string{`token=(?P<secret>\w+)`},
+	}
+	store, err := LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := store.GetClusterStore("cluster", now)
+	entityName := EntityName{Namespace: "default", Kind: "Pod", Name: "api"}
+	require.True(t, clusterStore.TryAdd(entityName, "failed to connect to postgres://admin:hunter2@db:5432", now))
+	require.True(t, clusterStore.TryAdd(entityName, "probe failed with token=s3cr3t", now))
+	require.False(t, clusterStore.TryAdd(entityName, "probe failed with token=s3cr3t", now), "redacted messages are deduplicated")
+	require.Nil(t, store.Flush(now))
+
+	content, err := ioutil.ReadFile(storeFile.Name())
+	require.Nil(t, err)
+	require.NotContains(t, string(content), "hunter2")
+	require.NotContains(t, string(content), "s3cr3t")
+	require.Contains(t, string(content), "[REDACTED]")
+}
+
+func TestLoadRedactsMessages(t *testing.T) {
+	storeFile, err := ioutil.TempFile(t.TempDir(), "*.store.json")
+	require.Nil(t, err)
+	now := time.Now().UTC()
+
+	cfg := &config.Config{
+		StoreFilePath:                 storeFile.Name(),
+		MessagesDeduplicationDuration: time.Minute,
+	}
+	store, err := LoadOrCreate(cfg)
+	require.Nil(t, err)
+	clusterStore := store.GetClusterStore("cluster", now)
+	entityName := EntityName{Namespace: "default", Kind: "Pod", Name: "api"}
+	require.True(t, clusterStore.TryAdd(entityName, "probe failed with token=s3cr3t", now))
+	require.Nil(t, store.FlushCluster(clusterStore, now))
+
+	cfg.RedactPatterns = []string{`token=(?P<secret>\w+)`}
+	store, err = LoadOrCreate(cfg)
+	require.Nil(t, err)
+	require.Nil(t, store.FlushCluster(store.GetClusterStore("other", now), now))
+
+	content, err := ioutil.ReadFile(storeFile.Name())
+	require.Nil(t, err)
+	require.NotContains(t, string(content), "s3cr3t", "messages stored before the redaction are redacted when loaded")
+}
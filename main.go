@@ -7,6 +7,8 @@ import (
 	"github.com/urfave/cli/v2"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 const VERSION = "0.1.16"
@@ -34,7 +36,27 @@ OPTIONS:
 			if err != nil {
 				return err
 			}
-			return pkg.Scout(cfg, nil)
+			// on interruption, e.g. when a job reaches its deadline, scanning stops and the partial results are reported
+			signalCtx, stop := signal.NotifyContext(ctx.Context, syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			if cfg.Watch {
+				return pkg.Watch(signalCtx, cfg, nil)
+			}
+			return pkg.ScoutWithContext(signalCtx, cfg, nil)
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "check-permissions",
+				Usage: "Check that the kubernetes permissions needed by the given flags are granted, per context, and print the minimal ClusterRole rules",
+				Flags: config.Flags,
+				Action: func(ctx *cli.Context) error {
+					cfg, err := config.ParseConfig(ctx)
+					if err != nil {
+						return err
+					}
+					return pkg.CheckPermissions(ctx.Context, cfg, os.Stdout)
+				},
+			},
 		},
 	}
 
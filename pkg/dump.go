@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal/diag"
+	"github.com/reallyliri/kubescout/internal/kubeclient"
+	"github.com/reallyliri/kubescout/internal/redact"
+	"github.com/reallyliri/kubescout/internal/store"
+	"github.com/reallyliri/kubescout/sink"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// scoutDump diagnoses the cluster dump at cfg.DumpDirPath, as of the time the dump was taken.
+// A dump is a one-off snapshot, so its alerts are neither deduplicated against nor saved to the store.
+func scoutDump(ctx context.Context, cfg *config.Config, alertSink sink.Sink, redactor *redact.Redactor) error {
+	client, err := kubeclient.CreateDumpClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	now := client.DumpTime()
+	if now.IsZero() {
+		log.Warnf("Dump at '%v' has no events to tell when it was taken, diagnosing as of now", cfg.DumpDirPath)
+		now = time.Now()
+	}
+	now = now.UTC()
+
+	dumpCfg := *cfg
+	dumpCfg.StoreFilePath = ""
+	stor, err := store.LoadOrCreate(&dumpCfg)
+	if err != nil {
+		return err
+	}
+
+	clusterName := filepath.Base(filepath.Clean(cfg.DumpDirPath))
+	clusterStore := stor.GetClusterStore(clusterName, now)
+
+	log.Infof("Diagnosing dump of cluster %v as of %v ...", clusterName, now.Format(cfg.TimeFormat))
+	err = diag.DiagnoseCluster(ctx, client, &dumpCfg, clusterStore, now)
+	if err != nil {
+		return fmt.Errorf("failed to diagnose dump of cluster %v: %v", clusterName, err)
+	}
+
+	clusterAlerts := clusterStore.Alerts
+	if len(clusterAlerts) == 0 {
+		return nil
+	}
+	sort.Sort(clusterAlerts)
+	alerts := alert.NewAlerts()
+	alerts.AddEntityAlerts(clusterAlerts)
+	alerts.AddCapabilities(clusterStore.Cluster, clusterStore.Capabilities)
+
+	limitLogs(cfg, alerts)
+	redactor.RedactAlerts(alerts)
+
+	err = alertSink.Report(alerts)
+	if err != nil {
+		return fmt.Errorf("failed to report alerts: %v", err)
+	}
+	return nil
+}
@@ -1,89 +1,119 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"github.com/reallyliri/kubescout/alert"
 	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal"
 	"github.com/reallyliri/kubescout/internal/diag"
 	"github.com/reallyliri/kubescout/internal/kubeclient"
 	"github.com/reallyliri/kubescout/internal/kubeconfig"
+	"github.com/reallyliri/kubescout/internal/redact"
 	"github.com/reallyliri/kubescout/internal/store"
 	"github.com/reallyliri/kubescout/sink"
 	log "github.com/sirupsen/logrus"
 	"go.uber.org/multierr"
 	"sort"
+	"sync"
 	"time"
 )
 
 // Scout the cluster for alerts. All parameters are optional, default values will be assigned, see CLI documentation.
 func Scout(cfg *config.Config, alertSink sink.Sink) error {
+	return ScoutWithContext(context.Background(), cfg, alertSink)
+}
+
+// ScoutWithContext is like Scout, but stops scanning once ctx is done, reporting the partial results
+func ScoutWithContext(ctx context.Context, cfg *config.Config, alertSink sink.Sink) error {
 	if alertSink == nil {
 		alertSink = cfg.DefaultSink()
 	}
 
-	stor, err := store.LoadOrCreate(cfg)
+	redactor, err := redact.NewRedactor(cfg.RedactPatterns)
 	if err != nil {
 		return err
 	}
 
-	var contextNames []string
-	var kconf kubeconfig.KubeConfig
+	if cfg.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.RunTimeout)
+		defer cancel()
+	}
 
-	if cfg.RunningInCluster {
-		contextNames = []string{"in-cluster"}
-	} else {
-		kconf, err = kubeconfig.LoadKubeconfig(cfg.KubeconfigFilePath)
-		if err != nil {
-			return err
-		}
+	if cfg.DumpDirPath != "" {
+		return scoutDump(ctx, cfg, alertSink, redactor)
+	}
+	if cfg.ReplayDirPath != "" {
+		return replay(ctx, cfg, alertSink, redactor)
+	}
+
+	stor, err := store.LoadOrCreate(cfg)
+	if err != nil {
+		return err
+	}
 
-		contextNames, err = kubeconfig.ContextNames(
-			kconf,
-			cfg.ContextName,
-			cfg.AllContexts,
-			cfg.ExcludeContexts,
-		)
+	if cfg.RecordDirPath != "" {
+		err = recordStore(cfg, stor)
 		if err != nil {
 			return err
 		}
 	}
 
-	alerts := alert.NewAlerts()
+	targets, err := resolveTargets(ctx, cfg)
+	if targets == nil {
+		return err
+	}
+	// clusters of invalid hub secrets are reported, while the rest are scanned
+	aggregatedErr := err
 
 	now := time.Now().UTC()
 
-	var aggregatedErr error
-	for i, contextName := range contextNames {
-		if kconf != nil {
-			kconf.CurrentContext = contextName
-		}
-
-		client, err := kubeclient.CreateClient(cfg, kconf)
-
-		if err != nil {
-			aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("failed to build kuberentes client for %v: %v", contextName, err))
-			continue
-		}
-
-		clusterStore := stor.GetClusterStore(contextName, now)
-
-		log.Infof("Diagnosing cluster %v (%v/%v) ...", contextName, i+1, len(contextNames))
+	// clusters are scanned concurrently, so an unreachable cluster does not hold back the rest,
+	// and their alerts are merged in the contexts order
+	results := make([]clusterResult, len(targets))
+	workersCount := cfg.ContextConcurrency
+	if workersCount > len(targets) {
+		workersCount = len(targets)
+	}
+	if workersCount < 1 {
+		workersCount = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workersCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				target := targets[index]
+				log.Infof("Diagnosing cluster %v (%v/%v) ...", target.name, index+1, len(targets))
+				results[index] = scoutCluster(ctx, cfg, target.kubeconfig, target.name, stor, redactor, now)
+			}
+		}()
+	}
+	for index := range targets {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
 
-		err = diag.DiagnoseCluster(client, cfg, clusterStore, now)
-		if err != nil {
-			aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("failed to diagnose cluster %v: %v", contextName, err))
-			continue
+	alerts := alert.NewAlerts()
+	for _, result := range results {
+		aggregatedErr = multierr.Append(aggregatedErr, result.err)
+		if result.clusterStore != nil {
+			alerts.AddEntityAlerts(result.clusterStore.Alerts)
+			alerts.AddCapabilities(result.clusterStore.Cluster, result.clusterStore.Capabilities)
 		}
-
-		clusterAlerts := clusterStore.Alerts
-		sort.Sort(clusterAlerts)
-		alerts.AddEntityAlerts(clusterAlerts)
 	}
 
 	if alerts.Empty() {
 		return aggregatedErr
 	}
 
+	limitLogs(cfg, alerts)
+	redactor.RedactAlerts(alerts)
+
 	err = alertSink.Report(alerts)
 	if err == nil {
 		flushErr := stor.Flush(now)
@@ -96,3 +126,115 @@ func Scout(cfg *config.Config, alertSink sink.Sink) error {
 
 	return aggregatedErr
 }
+
+type clusterResult struct {
+	// nil if the cluster was not diagnosed
+	clusterStore *store.ClusterStore
+	err          error
+}
+
+// scoutCluster diagnoses a single cluster, its errors do not affect the other clusters
+func scoutCluster(ctx context.Context, cfg *config.Config, kconf kubeconfig.KubeConfig, contextName string, stor *store.Store, redactor *redact.Redactor, now time.Time) (result clusterResult) {
+	startedAt := time.Now()
+	defer func() {
+		log.Infof("Diagnosed cluster %v in %v", contextName, time.Since(startedAt).Round(time.Millisecond))
+	}()
+
+	client, err := kubeclient.CreateClient(cfg, kconf)
+	if err != nil {
+		result.err = fmt.Errorf("failed to build kuberentes client for %v: %v", contextName, err)
+		return
+	}
+
+	var recorder *kubeclient.RecordingClient
+	if cfg.RecordDirPath != "" {
+		recorder = kubeclient.NewRecordingClient(client, redactor)
+		client = recorder
+	}
+
+	clusterStore := stor.GetClusterStore(contextName, now)
+
+	err = diag.DiagnoseCluster(ctx, client, cfg, clusterStore, now)
+	if recorder != nil {
+		recordErr := recorder.Save(recordingDirPath(cfg, contextName), contextName, now)
+		if recordErr != nil {
+			result.err = multierr.Append(result.err, fmt.Errorf("failed to record cluster %v: %v", contextName, recordErr))
+		}
+	}
+	if err != nil {
+		result.err = multierr.Append(result.err, fmt.Errorf("failed to diagnose cluster %v: %v", contextName, err))
+		return
+	}
+
+	sort.Sort(clusterStore.Alerts)
+	result.clusterStore = clusterStore
+	return
+}
+
+// scoutTarget is a cluster to scan, either a kubeconfig context or a cluster of the hub secrets
+type scoutTarget struct {
+	name string
+	// nil when running in cluster
+	kubeconfig kubeconfig.KubeConfig
+}
+
+// resolveTargets returns the clusters of the selected contexts, along with the clusters of the hub secrets if configured.
+// Secrets are listed on every call, so clusters added or removed between runs are picked up.
+// A nil result means there is nothing to scan, otherwise the error is of hub secrets which were skipped.
+func resolveTargets(ctx context.Context, cfg *config.Config) ([]scoutTarget, error) {
+	contextNames, kconf, err := resolveContexts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var targets []scoutTarget
+	scanned := internal.ToBoolMap(contextNames)
+	for _, contextName := range contextNames {
+		targets = append(targets, scoutTarget{name: contextName, kubeconfig: kubeconfig.WithCurrentContext(kconf, contextName)})
+	}
+	if cfg.HubSecretsSelector == "" {
+		return targets, nil
+	}
+
+	hubClusters, err := kubeclient.GetHubClusters(ctx, cfg, kconf)
+	if err != nil {
+		err = fmt.Errorf("failed to get clusters of hub secrets: %v", err)
+	}
+	for _, hubCluster := range hubClusters {
+		if scanned[hubCluster.Name] {
+			err = multierr.Append(err, fmt.Errorf("cluster %v of hub secrets is skipped, as a context of the same name is scanned", hubCluster.Name))
+			continue
+		}
+		scanned[hubCluster.Name] = true
+		targets = append(targets, scoutTarget{name: hubCluster.Name, kubeconfig: hubCluster.Kubeconfig})
+	}
+	return targets, err
+}
+
+func resolveContexts(cfg *config.Config) (contextNames []string, kconf kubeconfig.KubeConfig, err error) {
+	if cfg.RunningInCluster {
+		return []string{config.InClusterName}, nil, nil
+	}
+
+	kconf, err = kubeconfig.LoadKubeconfig(cfg.KubeconfigFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contextNames, err = kubeconfig.ContextNames(
+		kconf,
+		cfg.ContextNames,
+		cfg.AllContexts,
+		cfg.ExcludeContexts,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contextNames, kconf, nil
+}
+
+func limitLogs(cfg *config.Config, alerts *alert.Alerts) {
+	dropped := alerts.LimitLogs(cfg.LogsTotalBytes)
+	if dropped > 0 {
+		log.Infof("Dropped logs of %v containers of less severe alerts, to keep logs within %v bytes", dropped, cfg.LogsTotalBytes)
+	}
+}
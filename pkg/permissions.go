@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal/kubeclient"
+	"go.uber.org/multierr"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// CheckPermissions reviews, per cluster, whether kubescout is permitted to make every request its config needs.
+// It writes a matrix of allowed and denied permissions along with the minimal ClusterRole rules, and fails if any
+// required permission is missing. A namespaced permission denied cluster wide is enough if allowed in all included
+// namespaces. Missing optional permissions are written as warnings, along with what kubescout does without them.
+func CheckPermissions(ctx context.Context, cfg *config.Config, out io.Writer) error {
+	namespaces := cfg.IncludeNamespaces.ExactNames()
+
+	targets, err := resolveTargets(ctx, cfg)
+	if targets == nil {
+		return err
+	}
+	// clusters of invalid hub secrets are reported, while the rest are checked
+	aggregatedErr := err
+
+	for _, target := range targets {
+		checks, err := kubeclient.CheckPermissions(ctx, cfg, target.kubeconfig, namespaces)
+		if err != nil {
+			aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("failed to check permissions for %v: %v", target.name, err))
+			continue
+		}
+
+		_, _ = fmt.Fprintf(out, "Permissions for cluster %v:\n", target.name)
+		missing, fallbacks := writePermissionsMatrix(out, checks, namespaces)
+		for _, fallback := range fallbacks {
+			_, _ = fmt.Fprintf(out, "Warning: %v\n", fallback)
+		}
+		_, _ = fmt.Fprintln(out)
+		if len(missing) > 0 {
+			aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("missing permissions for %v: %v", target.name, strings.Join(missing, ", ")))
+		}
+	}
+
+	_, _ = fmt.Fprintf(out, "Minimal ClusterRole rules:\n%v", clusterRoleRules(kubeclient.RequiredPermissions(cfg)))
+	return aggregatedErr
+}
+
+// writePermissionsMatrix with a row per permission and a column per scope, and returns the missing required
+// permissions, along with the fallbacks of the missing optional permissions
+func writePermissionsMatrix(out io.Writer, checks []kubeclient.PermissionCheck, namespaces []string) (missing []string, fallbacks []string) {
+	var permissions []kubeclient.Permission
+	allowedByScope := map[kubeclient.Permission]map[string]bool{}
+	for _, check := range checks {
+		if _, found := allowedByScope[check.Permission]; !found {
+			permissions = append(permissions, check.Permission)
+			allowedByScope[check.Permission] = map[string]bool{}
+		}
+		allowedByScope[check.Permission][check.Namespace] = check.Allowed
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintf(writer, "PERMISSION\tCLUSTER")
+	for _, namespace := range namespaces {
+		_, _ = fmt.Fprintf(writer, "\t%v", namespace)
+	}
+	_, _ = fmt.Fprintln(writer)
+
+	for _, permission := range permissions {
+		allowed := allowedByScope[permission]
+		_, _ = fmt.Fprintf(writer, "%v\t%v", permission, allowedText(allowed[""], true))
+		allowedInNamespaces := permission.Namespaced && len(namespaces) > 0
+		for _, namespace := range namespaces {
+			_, _ = fmt.Fprintf(writer, "\t%v", allowedText(allowed[namespace], permission.Namespaced))
+			allowedInNamespaces = allowedInNamespaces && allowed[namespace]
+		}
+		if !allowed[""] && !allowedInNamespaces {
+			if permission.Fallback == "" {
+				missing = append(missing, permission.String())
+			} else {
+				_, _ = fmt.Fprintf(writer, "\t(optional)")
+				fallbacks = append(fallbacks, fmt.Sprintf("%v is denied, %v", permission, permission.Fallback))
+			}
+		}
+		_, _ = fmt.Fprintln(writer)
+	}
+	_ = writer.Flush()
+	return
+}
+
+func allowedText(allowed bool, applicable bool) string {
+	if !applicable {
+		return "-"
+	}
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+// clusterRoleRules in the format of the helm chart rbac template, a rule per api group and verbs
+func clusterRoleRules(permissions []kubeclient.Permission) string {
+	type resourceKey struct {
+		group    string
+		resource string
+	}
+	var resources []resourceKey
+	verbsByResource := map[resourceKey][]string{}
+	for _, permission := range permissions {
+		key := resourceKey{group: permission.Group, resource: permission.ResourcePath()}
+		if _, found := verbsByResource[key]; !found {
+			resources = append(resources, key)
+		}
+		verbsByResource[key] = append(verbsByResource[key], permission.Verb)
+	}
+
+	type ruleKey struct {
+		group string
+		verbs string
+	}
+	var rules []ruleKey
+	resourcesByRule := map[ruleKey][]string{}
+	for _, resource := range resources {
+		key := ruleKey{group: resource.group, verbs: quotedList(verbsByResource[resource])}
+		if _, found := resourcesByRule[key]; !found {
+			rules = append(rules, key)
+		}
+		resourcesByRule[key] = append(resourcesByRule[key], resource.resource)
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString("rules:\n")
+	for _, rule := range rules {
+		builder.WriteString(fmt.Sprintf("  - apiGroups: [ %v ]\n", quotedList([]string{rule.group})))
+		builder.WriteString(fmt.Sprintf("    resources: [ %v ]\n", quotedList(resourcesByRule[rule])))
+		builder.WriteString(fmt.Sprintf("    verbs: [ %v ]\n", rule.verbs))
+	}
+	return builder.String()
+}
+
+func quotedList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return strings.Join(quoted, ", ")
+}
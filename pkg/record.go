@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal/diag"
+	"github.com/reallyliri/kubescout/internal/kubeclient"
+	"github.com/reallyliri/kubescout/internal/redact"
+	"github.com/reallyliri/kubescout/internal/store"
+	"github.com/reallyliri/kubescout/sink"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// the store as it was before the recorded scan, so replaying deduplicates alerts the same way
+const recordedStoreFileName = "store.json"
+
+var unsafeDirNameCharsRegex = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// recordingDirPath of a cluster, context names such as eks arns are not valid directory names
+func recordingDirPath(cfg *config.Config, contextName string) string {
+	return filepath.Join(cfg.RecordDirPath, unsafeDirNameCharsRegex.ReplaceAllString(contextName, "_"))
+}
+
+func recordStore(cfg *config.Config, stor *store.Store) error {
+	err := stor.Snapshot(filepath.Join(cfg.RecordDirPath, recordedStoreFileName))
+	if err != nil {
+		return fmt.Errorf("failed to record store: %v", err)
+	}
+	return nil
+}
+
+// replay diagnoses the clusters recorded to cfg.ReplayDirPath, each as of the time it was recorded.
+// The recorded store is only read, so a recording can be replayed any number of times with the same results.
+func replay(ctx context.Context, cfg *config.Config, alertSink sink.Sink, redactor *redact.Redactor) error {
+	entries, err := ioutil.ReadDir(cfg.ReplayDirPath)
+	if err != nil {
+		return fmt.Errorf("failed to read recordings from '%v': %v", cfg.ReplayDirPath, err)
+	}
+	var recordingDirPaths []string
+	for _, entry := range entries {
+		dirPath := filepath.Join(cfg.ReplayDirPath, entry.Name())
+		if entry.IsDir() && kubeclient.IsRecording(dirPath) {
+			recordingDirPaths = append(recordingDirPaths, dirPath)
+		}
+	}
+	if len(recordingDirPaths) == 0 {
+		return fmt.Errorf("no recordings were found at '%v'", cfg.ReplayDirPath)
+	}
+	sort.Strings(recordingDirPaths)
+
+	replayCfg := *cfg
+	replayCfg.StoreFilePath = filepath.Join(cfg.ReplayDirPath, recordedStoreFileName)
+	stor, err := store.LoadOrCreate(&replayCfg)
+	if err != nil {
+		return err
+	}
+
+	alerts := alert.NewAlerts()
+
+	var aggregatedErr error
+	for i, dirPath := range recordingDirPaths {
+		client, err := kubeclient.CreateReplayClient(dirPath)
+		if err != nil {
+			aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("failed to load recording from '%v': %v", dirPath, err))
+			continue
+		}
+
+		clusterName := client.Recording.Cluster
+		now := client.Recording.Now
+		clusterStore := stor.GetClusterStore(clusterName, now)
+
+		log.Infof("Replaying diagnosis of cluster %v as of %v (%v/%v) ...", clusterName, now.Format(cfg.TimeFormat), i+1, len(recordingDirPaths))
+
+		err = diag.DiagnoseCluster(ctx, client, &replayCfg, clusterStore, now)
+		if err != nil {
+			aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("failed to diagnose cluster %v: %v", clusterName, err))
+			continue
+		}
+
+		clusterAlerts := clusterStore.Alerts
+		sort.Sort(clusterAlerts)
+		alerts.AddEntityAlerts(clusterAlerts)
+		alerts.AddCapabilities(clusterStore.Cluster, clusterStore.Capabilities)
+	}
+
+	if alerts.Empty() {
+		return aggregatedErr
+	}
+
+	limitLogs(cfg, alerts)
+	redactor.RedactAlerts(alerts)
+
+	err = alertSink.Report(alerts)
+	if err != nil {
+		aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("failed to report alerts: %v", err))
+	}
+	return aggregatedErr
+}
@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/reallyliri/kubescout/alert"
+	"github.com/reallyliri/kubescout/config"
+	"github.com/reallyliri/kubescout/internal/diag"
+	"github.com/reallyliri/kubescout/internal/kubeclient"
+	"github.com/reallyliri/kubescout/internal/redact"
+	"github.com/reallyliri/kubescout/internal/store"
+	"github.com/reallyliri/kubescout/sink"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
+	"sort"
+	"sync"
+	"time"
+)
+
+type watcher struct {
+	cfg       *config.Config
+	alertSink sink.Sink
+	redactor  *redact.Redactor
+	// the store is shared by all watched clusters, which are flushed and reverted each on its own
+	stor *store.Store
+	// a lock per cluster, so clusters are diagnosed concurrently while each cluster is diagnosed one at a time
+	locks map[string]*sync.Mutex
+}
+
+// Watch the clusters and diagnose each of them whenever its objects change, until ctx is done.
+// Alerts go through the same store deduplication as Scout, so re-evaluations do not repeat reported alerts.
+// Clusters of the hub secrets are resolved once, when the watch starts.
+func Watch(ctx context.Context, cfg *config.Config, alertSink sink.Sink) error {
+	if alertSink == nil {
+		alertSink = cfg.DefaultSink()
+	}
+
+	redactor, err := redact.NewRedactor(cfg.RedactPatterns)
+	if err != nil {
+		return err
+	}
+
+	stor, err := store.LoadOrCreate(cfg)
+	if err != nil {
+		return err
+	}
+
+	targets, err := resolveTargets(ctx, cfg)
+	if targets == nil {
+		return err
+	}
+	// clusters of invalid hub secrets are reported, while the rest are watched
+	aggregatedErr := err
+	if aggregatedErr != nil {
+		log.Errorf("%v", aggregatedErr)
+	}
+
+	w := &watcher{
+		cfg:       cfg,
+		alertSink: alertSink,
+		redactor:  redactor,
+		stor:      stor,
+		locks:     map[string]*sync.Mutex{},
+	}
+	for _, target := range targets {
+		w.locks[target.name] = &sync.Mutex{}
+	}
+
+	// clusters are started concurrently, so a cluster which is unreachable or slow to sync does not hold back the rest
+	var errorsLock sync.Mutex
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target scoutTarget) {
+			defer wg.Done()
+			err := w.watch(ctx, target)
+			if err != nil {
+				log.Errorf("%v", err)
+				errorsLock.Lock()
+				defer errorsLock.Unlock()
+				aggregatedErr = multierr.Append(aggregatedErr, err)
+			}
+		}(target)
+	}
+
+	wg.Wait()
+	return aggregatedErr
+}
+
+// watch the cluster until ctx is done, reporting it as incomplete if it can not be watched or is not synced in time
+func (w *watcher) watch(ctx context.Context, target scoutTarget) error {
+	contextName := target.name
+	client, err := kubeclient.CreateInformerClient(w.cfg, target.kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to build kuberentes client for %v: %v", contextName, err)
+	}
+
+	log.Infof("Starting to watch cluster %v ...", contextName)
+	changes := make(chan struct{}, 1)
+	err = client.Start(ctx, func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to watch cluster %v: %v", contextName, err)
+		w.reportIncomplete(contextName, err)
+		return err
+	}
+
+	syncCtx := ctx
+	if w.cfg.WatchSyncTimeout > 0 {
+		var cancel context.CancelFunc
+		syncCtx, cancel = context.WithTimeout(ctx, w.cfg.WatchSyncTimeout)
+		defer cancel()
+	}
+	err = client.WaitForSync(syncCtx)
+	if err != nil && ctx.Err() == nil {
+		log.Warnf("Cluster %v was not synced within %v, waiting for it in the background", contextName, w.cfg.WatchSyncTimeout)
+		w.reportIncomplete(contextName, fmt.Errorf("cluster was not synced within %v", w.cfg.WatchSyncTimeout))
+		err = client.WaitForSync(ctx)
+	}
+	if err != nil {
+		// ctx is done
+		return nil
+	}
+	log.Infof("Watching cluster %v", contextName)
+
+	w.loop(ctx, contextName, client, changes)
+	return nil
+}
+
+// reportIncomplete reports a 'scan incomplete' alert of a cluster which could not be diagnosed
+func (w *watcher) reportIncomplete(contextName string, cause error) {
+	lock := w.locks[contextName]
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := time.Now().UTC()
+	clusterStore := w.stor.GetClusterStore(contextName, now)
+	diag.DiagnoseIncompleteScan(w.cfg, clusterStore, now, cause)
+	err := w.report(contextName, clusterStore, now)
+	if err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+func (w *watcher) loop(ctx context.Context, contextName string, client kubeclient.KubernetesClient, changes chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		}
+
+		// the diagnosis waits for a fixed period after the first change rather than for a quiet period,
+		// as events keep changing in a busy cluster
+		timer := time.NewTimer(w.cfg.WatchDebounceDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		select {
+		case <-changes:
+		default:
+		}
+
+		err := w.diagnose(ctx, contextName, client)
+		if err != nil {
+			log.Errorf("%v", err)
+		}
+	}
+}
+
+func (w *watcher) diagnose(ctx context.Context, contextName string, client kubeclient.KubernetesClient) error {
+	lock := w.locks[contextName]
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := time.Now().UTC()
+	clusterStore := w.stor.GetClusterStore(contextName, now)
+
+	log.Debugf("Diagnosing cluster %v ...", contextName)
+	if w.cfg.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.cfg.RunTimeout)
+		defer cancel()
+	}
+	err := diag.DiagnoseCluster(ctx, client, w.cfg, clusterStore, now)
+	if err != nil {
+		return fmt.Errorf("failed to diagnose cluster %v: %v", contextName, err)
+	}
+
+	return w.report(contextName, clusterStore, now)
+}
+
+// report the alerts of the cluster store, and flush it once they are reported
+func (w *watcher) report(contextName string, clusterStore *store.ClusterStore, now time.Time) error {
+	clusterAlerts := clusterStore.Alerts
+	if len(clusterAlerts) == 0 {
+		return nil
+	}
+	sort.Sort(clusterAlerts)
+	alerts := alert.NewAlerts()
+	alerts.AddEntityAlerts(clusterAlerts)
+	alerts.AddCapabilities(clusterStore.Cluster, clusterStore.Capabilities)
+
+	limitLogs(w.cfg, alerts)
+	w.redactor.RedactAlerts(alerts)
+
+	err := w.alertSink.Report(alerts)
+	if err != nil {
+		// revert the cluster store so the unreported alerts are not deduplicated on the next diagnosis
+		revertErr := w.stor.RevertCluster(contextName)
+		if revertErr != nil {
+			log.Errorf("failed to revert store of cluster %v: %v", contextName, revertErr)
+		}
+		return fmt.Errorf("failed to report alerts: %v", err)
+	}
+
+	err = w.stor.FlushCluster(clusterStore, now)
+	if err != nil {
+		return fmt.Errorf("failed to flush to store: %v", err)
+	}
+	return nil
+}